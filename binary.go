@@ -0,0 +1,242 @@
+package pake
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/tscholl2/siec"
+)
+
+// The binary encoding of a message is a header byte, a byte with the
+// mode and the transcript version in format 2, and the compressed point
+// of the sender, X for role 0 and Y for role 1. The header is laid out
+// as
+//
+//	1 | format (2 bits) | curve ID (4 bits) | role (1 bit)
+//
+// and the second byte of format 2 as
+//
+//	mode (4 bits) | transcript version (4 bits)
+//
+// The top bit is always set, so a binary message never starts like
+// the JSON encoding produced by Bytes. Format 1 has no second byte and
+// implies ModeLegacy and transcript version 1, which keeps it readable
+// by peers that predate Pake.Version.
+const (
+	binaryMarker  = 0x80
+	binaryFormat1 = 1
+	binaryFormat2 = 2
+)
+
+// binaryCurveIDs are the curve identifiers of the binary encoding.
+// They must never be reused for another curve. Curves added with
+// RegisterCurve have no identifier and are JSON-only.
+var binaryCurveIDs = map[string]byte{
+	"p256":         1,
+	"p384":         2,
+	"p521":         3,
+	"siec":         4,
+	"ed25519":      5,
+	"ristretto255": 6,
+}
+
+// binaryCurveName returns the name of the curve with the given ID.
+func binaryCurveName(id byte) (string, bool) {
+	for name, curveID := range binaryCurveIDs {
+		if curveID == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// MarshalBinary encodes the public part of p in the compact binary
+// format: a header byte with the format, curve and role, a byte with
+// the mode and the transcript version unless they are ModeLegacy and 1,
+// and the compressed point X (role 0) or Y (role 1). Update accepts it
+// in place of Bytes. Only the built-in curves have an identifier in the
+// header: for a curve added with RegisterCurve, MarshalBinary returns
+// ErrUnknownCurve and the messages must be sent with Bytes.
+func (p *Pake) MarshalBinary() ([]byte, error) {
+	if p == nil {
+		return nil, ErrNotInitialized
+	}
+	if p.negotiation != nil {
+		return nil, fmt.Errorf("%w: the curve is not negotiated yet", ErrUnknownCurve)
+	}
+	id, ok := binaryCurveIDs[p.Curve]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q has no binary curve ID, use Bytes", ErrUnknownCurve, p.Curve)
+	}
+	x, y := p.Xᵤ, p.Xᵥ
+	if p.Role == 1 {
+		x, y = p.Yᵤ, p.Yᵥ
+	}
+	if x == nil || y == nil {
+		return nil, fmt.Errorf("%w: Y is computed by Update", ErrNotInitialized)
+	}
+	if p.Mode == ModeLegacy && p.Version == transcriptVersion1 {
+		header := byte(binaryMarker | binaryFormat1<<5 | int(id)<<1 | p.Role&1)
+		return append([]byte{header}, compressPoint(p.curve, p.P, x, y)...), nil
+	}
+	header := byte(binaryMarker | binaryFormat2<<5 | int(id)<<1 | p.Role&1)
+	params := byte(p.Mode)<<4 | byte(p.Version)
+	return append([]byte{header, params}, compressPoint(p.curve, p.P, x, y)...), nil
+}
+
+// UnmarshalBinary decodes a message produced by MarshalBinary into p,
+// setting the curve, the mode, the role and the point of the sender. U and V
+// are not part of the message and are left unset. Messages of registered
+// curves cannot be encoded in this format. The point is
+// checked to be on the curve, but not whether it is of small order,
+// which Update does.
+func (p *Pake) UnmarshalBinary(data []byte) error {
+	if p == nil {
+		return ErrNotInitialized
+	}
+	if len(data) < 2 || data[0]&binaryMarker == 0 {
+		return fmt.Errorf("%w: not a binary message", ErrMalformedMessage)
+	}
+	mode, version, point := ModeLegacy, transcriptVersion1, data[1:]
+	switch format := data[0] >> 5 & 0x03; format {
+	case binaryFormat1:
+	case binaryFormat2:
+		mode, version, point = Mode(data[1]>>4), int(data[1]&0x0f), data[2:]
+		if mode > ModeCPace {
+			return fmt.Errorf("%w: mode %d", ErrMalformedMessage, mode)
+		}
+		if version < transcriptVersion1 || version > latestTranscriptVersion {
+			return fmt.Errorf("%w: transcript version %d", ErrUnsupportedVersion, version)
+		}
+	default:
+		return fmt.Errorf("%w: binary format %d", ErrUnsupportedVersion, format)
+	}
+	curveName, ok := binaryCurveName(data[0] >> 1 & 0x0f)
+	if !ok {
+		return fmt.Errorf("%w: binary curve ID %d", ErrUnknownCurve, data[0]>>1&0x0f)
+	}
+	curve, P, _, _, _, _, err := initCurve(curveName)
+	if err != nil {
+		return err
+	}
+	x, y, err := decompressPoint(curve, P, point)
+	if err != nil {
+		return err
+	}
+	*p = Pake{
+		Role:    int(data[0] & 1),
+		Curve:   curveName,
+		Version: version,
+		curve:   curve,
+		P:       P,
+		Mode:    mode,
+	}
+	if p.Role == 0 {
+		p.Xᵤ, p.Xᵥ = x, y
+	} else {
+		p.Yᵤ, p.Yᵥ = x, y
+	}
+	return nil
+}
+
+// maxMessageSize bounds the messages of the other party, so that a
+// hostile peer cannot make Update parse arbitrarily large numbers.
+const maxMessageSize = 1 << 16
+
+// decodeMessage decodes a message of the other party, which is either
+// in the binary format or, for backward compatibility, JSON, and
+// checks that it is of the given mode.
+func decodeMessage(b []byte, mode Mode) (q *Pake, err error) {
+	if len(b) > maxMessageSize {
+		return nil, fmt.Errorf("%w: message too large", ErrMalformedMessage)
+	}
+	if len(b) > 0 && b[0]&binaryMarker != 0 {
+		q = new(Pake)
+		if err = q.UnmarshalBinary(b); err != nil {
+			return nil, err
+		}
+	} else if err = json.Unmarshal(b, &q); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+	if q == nil {
+		return nil, fmt.Errorf("%w: null message", ErrMalformedMessage)
+	}
+	if q.Role != 0 && q.Role != 1 {
+		return nil, fmt.Errorf("%w: role %d", ErrMalformedMessage, q.Role)
+	}
+	if q.Mode != mode {
+		return nil, fmt.Errorf("%w: message of mode %d, expected %d", ErrModeMismatch, q.Mode, mode)
+	}
+	return q, nil
+}
+
+// compressPoint returns the compressed encoding of (x, y): the 32-byte
+// encoding for Edwards25519 and ristretto255 and the SEC 1 compressed
+// form for the Weierstrass curves.
+func compressPoint(curve EllipticCurve, P, x, y *big.Int) []byte {
+	switch curve.(type) {
+	case *Edwards25519Curve, *Ristretto255Curve:
+		return ed25519PointFromBigInts(x, y)
+	}
+	size := (P.BitLen() + 7) / 8
+	b := make([]byte, 1+size)
+	b[0] = byte(2 + y.Bit(0))
+	x.FillBytes(b[1:])
+	return b
+}
+
+// decompressPoint is the inverse of compressPoint and fails if b does
+// not encode a point on the curve.
+func decompressPoint(curve EllipticCurve, P *big.Int, b []byte) (x, y *big.Int, err error) {
+	switch c := curve.(type) {
+	case *Edwards25519Curve, *Ristretto255Curve:
+		if len(b) != 32 {
+			return nil, nil, fmt.Errorf("%w: invalid encoding", ErrInvalidPoint)
+		}
+		x, y = ed25519PointToBigInts(b)
+	case elliptic.Curve:
+		x, y = elliptic.UnmarshalCompressed(c, b)
+		if x == nil {
+			return nil, nil, fmt.Errorf("%w: invalid encoding", ErrInvalidPoint)
+		}
+	case *siec.SIEC255Params:
+		x, y = decompressSIEC(c, b)
+		if x == nil {
+			return nil, nil, fmt.Errorf("%w: invalid encoding", ErrInvalidPoint)
+		}
+	default:
+		return nil, nil, ErrUnknownCurve
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, nil, fmt.Errorf("%w: invalid encoding", ErrInvalidPoint)
+	}
+	return x, y, nil
+}
+
+// decompressSIEC decompresses a SEC 1 compressed point on
+// y² = x³ + Ax + B, returning nil if b is not a valid encoding.
+func decompressSIEC(c *siec.SIEC255Params, b []byte) (x, y *big.Int) {
+	size := (c.P.BitLen() + 7) / 8
+	if len(b) != 1+size || (b[0] != 2 && b[0] != 3) {
+		return nil, nil
+	}
+	x = new(big.Int).SetBytes(b[1:])
+	if x.Cmp(c.P) >= 0 {
+		return nil, nil
+	}
+	y2 := new(big.Int).Exp(x, big.NewInt(3), c.P)
+	y2.Add(y2, new(big.Int).Mul(c.A, x))
+	y2.Add(y2, c.B)
+	y2.Mod(y2, c.P)
+	y = new(big.Int).ModSqrt(y2, c.P)
+	if y == nil || (y.Sign() == 0 && b[0] == 3) {
+		return nil, nil
+	}
+	if y.Bit(0) != uint(b[0]&1) {
+		y.Sub(c.P, y)
+		y.Mod(y, c.P)
+	}
+	return x, y
+}
@@ -0,0 +1,191 @@
+package pake
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBinary(t *testing.T) {
+	for _, curve := range AvailableCurves() {
+		A, err := InitCurve([]byte("password"), 0, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err := InitCurve([]byte("password"), 1, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgA, err := A.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgA) >= len(A.Bytes()) {
+			t.Errorf("binary message for %s is not smaller than JSON: %d bytes", curve, len(msgA))
+		}
+		if err = B.Update(msgA); err != nil {
+			t.Fatalf("%s: %v", curve, err)
+		}
+		msgB, err := B.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = A.Update(msgB); err != nil {
+			t.Fatalf("%s: %v", curve, err)
+		}
+		kA, _ := A.SessionKey()
+		kB, _ := B.SessionKey()
+		if !bytes.Equal(kA, kB) {
+			t.Errorf("session keys not equal for %s", curve)
+		}
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for _, curve := range AvailableCurves() {
+		for i := 0; i < 10; i++ {
+			A, err := InitCurve([]byte("password"), 0, curve)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := A.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := new(Pake)
+			if err = q.UnmarshalBinary(b); err != nil {
+				t.Fatalf("%s: %v", curve, err)
+			}
+			if q.Role != 0 || q.Xᵤ.Cmp(A.Xᵤ) != 0 || q.Xᵥ.Cmp(A.Xᵥ) != 0 {
+				t.Errorf("%s: X did not survive the round trip", curve)
+			}
+			if q.Curve != curve {
+				t.Errorf("%s: got curve %q after the round trip", curve, q.Curve)
+			}
+		}
+	}
+}
+
+func TestBinaryTranscriptVersion(t *testing.T) {
+	for _, version := range []int{transcriptVersion1, transcriptVersion2} {
+		A, err := InitCurveWithOptions([]byte("password"), 0, "p256", Options{TranscriptVersion: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := A.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// version 1 keeps the format without the version byte
+		format, size := binaryFormat2, 35
+		if version == transcriptVersion1 {
+			format, size = binaryFormat1, 34
+		}
+		if int(b[0]>>5&0x03) != format || len(b) != size {
+			t.Errorf("version %d: got format %d and %d bytes", version, b[0]>>5&0x03, len(b))
+		}
+		q := new(Pake)
+		if err = q.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		if q.Version != version {
+			t.Errorf("version %d: decoded version %d", version, q.Version)
+		}
+	}
+}
+
+func TestBinaryMode(t *testing.T) {
+	for _, mode := range []Mode{ModeLegacy, ModeRFC9382, ModeSPAKE2Plus, ModeCPace} {
+		A, err := InitCurveWithOptions([]byte("password"), 0, modeCurve(mode), Options{Mode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := A.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = decodeMessage(b, mode); err != nil {
+			t.Errorf("mode %d: %v", mode, err)
+		}
+		if _, err = decodeMessage(A.Bytes(), mode); err != nil {
+			t.Errorf("mode %d in JSON: %v", mode, err)
+		}
+		if _, err = decodeMessage(A.Bytes(), (mode+1)%4); !errors.Is(err, ErrModeMismatch) {
+			t.Errorf("mode %d in JSON read as mode %d: got %v", mode, (mode+1)%4, err)
+		}
+		// a peer in another mode cannot read the message
+		if _, err = decodeMessage(b, (mode+1)%4); !errors.Is(err, ErrModeMismatch) {
+			t.Errorf("mode %d read as mode %d: got %v", mode, (mode+1)%4, err)
+		}
+	}
+}
+
+func TestBinaryMixedFormats(t *testing.T) {
+	A, err := InitCurve([]byte("password"), 0, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := InitCurve([]byte("password"), 1, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A still speaks JSON, B answers in binary
+	if err = B.Update(A.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	msgB, err := B.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = A.Update(msgB); err != nil {
+		t.Fatal(err)
+	}
+	kA, _ := A.SessionKey()
+	kB, _ := B.SessionKey()
+	if !bytes.Equal(kA, kB) {
+		t.Error("session keys not equal")
+	}
+}
+
+func TestBinaryInvalid(t *testing.T) {
+	B, err := InitCurve([]byte("password"), 1, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = B.MarshalBinary(); err == nil {
+		t.Error("B should not marshal before computing Y")
+	}
+	A, err := InitCurveWithOptions([]byte("password"), 0, "p256", Options{TranscriptVersion: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := A.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	badFormat := append([]byte{msg[0] &^ 0x60}, msg[1:]...)
+	badVersion := append([]byte{msg[0], 3}, msg[2:]...)
+	badMode := append([]byte{msg[0], 0xf0 | msg[1]}, msg[2:]...)
+	badCurve := append([]byte{msg[0] | 0x1e}, msg[1:]...)
+	badPrefix := append([]byte{msg[0], msg[1], 0x04}, msg[3:]...)
+	outOfRange := append([]byte{}, msg...)
+	for i := 3; i < len(outOfRange); i++ {
+		outOfRange[i] = 0xff
+	}
+	for name, b := range map[string][]byte{
+		"empty":        {},
+		"header only":  msg[:1],
+		"no point":     msg[:2],
+		"truncated":    msg[:len(msg)-1],
+		"bad format":   badFormat,
+		"bad version":  badVersion,
+		"bad mode":     badMode,
+		"bad curve":    badCurve,
+		"bad prefix":   badPrefix,
+		"out of range": outOfRange,
+	} {
+		if err := new(Pake).UnmarshalBinary(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
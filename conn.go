@@ -0,0 +1,281 @@
+package pake
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+)
+
+// ConnOptions configures Client and Server.
+type ConnOptions struct {
+	// Curves are negotiated as with InitNegotiation: the client offers
+	// them in order of preference and the server picks the first one it
+	// also supports. The default is p256.
+	Curves []string
+
+	Options
+}
+
+const (
+	// maxFrameSize is the largest frame accepted from the other party.
+	maxFrameSize = 1 << 16
+	// maxPayloadSize is the largest plaintext sealed in one frame.
+	maxPayloadSize = maxFrameSize - 16
+)
+
+var errFrameTooLarge = fmt.Errorf("%w: frame too large", ErrMalformedMessage)
+
+// secureConn encrypts the traffic of the underlying connection with
+// AES-GCM, using one key per direction and the sequence number of each
+// frame as nonce.
+type secureConn struct {
+	net.Conn
+
+	readMu  sync.Mutex
+	readKey cipher.AEAD
+	readSeq uint64
+	readBuf []byte
+
+	writeMu  sync.Mutex
+	writeKey cipher.AEAD
+	writeSeq uint64
+
+	// the authentication failure after which the connection is no
+	// longer trusted, returned by every later Read and Write
+	errMu sync.Mutex
+	err   error
+}
+
+// Client runs the PAKE over conn as role 0, using the password pw, and
+// returns a connection that encrypts all traffic with keys derived from
+// the session key. The server must use the same password, mode and
+// identities. If the handshake fails, conn is closed.
+func Client(conn net.Conn, pw []byte, opts ConnOptions) (net.Conn, error) {
+	c, err := handshake(conn, pw, 0, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Server runs the PAKE over conn as role 1, see Client.
+func Server(conn net.Conn, pw []byte, opts ConnOptions) (net.Conn, error) {
+	c, err := handshake(conn, pw, 1, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake exchanges the PAKE messages and the key confirmation tags,
+// each in its own frame: the client sends its offer, the server answers
+// with its message and its tag, and the client sends its tag last.
+func handshake(conn net.Conn, pw []byte, role int, opts ConnOptions) (c *secureConn, err error) {
+	curves := opts.Curves
+	if len(curves) == 0 {
+		curves = []string{"p256"}
+	}
+	p, err := InitNegotiation(pw, role, curves, opts.Options)
+	if err != nil {
+		return
+	}
+
+	if role == 0 {
+		if err = writeFrame(conn, p.Bytes()); err != nil {
+			return
+		}
+		if err = updateFromFrame(conn, p); err != nil {
+			return
+		}
+		if err = verifyFromFrame(conn, p); err != nil {
+			return
+		}
+		if err = writeConfirmation(conn, p); err != nil {
+			return
+		}
+	} else {
+		if err = updateFromFrame(conn, p); err != nil {
+			return
+		}
+		if err = writeFrame(conn, p.Bytes()); err != nil {
+			return
+		}
+		if err = writeConfirmation(conn, p); err != nil {
+			return
+		}
+		if err = verifyFromFrame(conn, p); err != nil {
+			return
+		}
+	}
+
+	clientKey, err := newConnKey(p, "pake conn client to server")
+	if err != nil {
+		return
+	}
+	serverKey, err := newConnKey(p, "pake conn server to client")
+	if err != nil {
+		return
+	}
+	c = &secureConn{Conn: conn, readKey: clientKey, writeKey: serverKey}
+	if role == 0 {
+		c.readKey, c.writeKey = serverKey, clientKey
+	}
+	return
+}
+
+func updateFromFrame(conn net.Conn, p *Pake) error {
+	b, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+	return p.Update(b)
+}
+
+func writeConfirmation(conn net.Conn, p *Pake) error {
+	tag, err := p.Confirmation()
+	if err != nil {
+		return err
+	}
+	return writeFrame(conn, tag)
+}
+
+func verifyFromFrame(conn net.Conn, p *Pake) error {
+	tag, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+	return p.VerifyConfirmation(tag)
+}
+
+// newConnKey returns the AES-256-GCM cipher keyed with the key
+// exported for label.
+func newConnKey(p *Pake, label string) (cipher.AEAD, error) {
+	key, err := p.Export(label, nil, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFrame writes b preceded by its length as a 4-byte big-endian
+// integer.
+func writeFrame(w io.Writer, b []byte) error {
+	if len(b) > maxFrameSize {
+		return errFrameTooLarge
+	}
+	frame := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads a frame written by writeFrame.
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxFrameSize {
+		return nil, errFrameTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// nonce returns the GCM nonce for the frame with sequence number seq.
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+	return n
+}
+
+// Read reads and decrypts data from the connection. It fails if a
+// frame was modified, reordered, replayed or dropped, and from then on
+// every Read and Write returns the same error.
+func (c *secureConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if err := c.failure(); err != nil {
+		return 0, err
+	}
+	for len(c.readBuf) == 0 {
+		frame, err := readFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if c.readSeq == math.MaxUint64 {
+			return 0, ErrSequenceExhausted
+		}
+		c.readBuf, err = c.readKey.Open(frame[:0], nonce(c.readKey, c.readSeq), frame, nil)
+		if err != nil {
+			return 0, c.fail(fmt.Errorf("%w: message authentication failed", ErrMalformedMessage))
+		}
+		c.readSeq++
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// Write encrypts and writes data to the connection, split into frames
+// of at most maxPayloadSize bytes.
+func (c *secureConn) Write(b []byte) (n int, err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err = c.failure(); err != nil {
+		return
+	}
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxPayloadSize {
+			chunk = chunk[:maxPayloadSize]
+		}
+		if c.writeSeq == math.MaxUint64 {
+			return n, ErrSequenceExhausted
+		}
+		sealed := c.writeKey.Seal(nil, nonce(c.writeKey, c.writeSeq), chunk, nil)
+		if err = writeFrame(c.Conn, sealed); err != nil {
+			return
+		}
+		c.writeSeq++
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return
+}
+
+// fail records err as the authentication failure of c and returns it.
+func (c *secureConn) fail(err error) error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	return c.err
+}
+
+// failure returns the authentication failure of c, if any.
+func (c *secureConn) failure() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
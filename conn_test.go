@@ -0,0 +1,125 @@
+package pake
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+type connResult struct {
+	conn net.Conn
+	err  error
+}
+
+func connect(t *testing.T, pwClient, pwServer []byte, optsClient, optsServer ConnOptions) (client, server connResult) {
+	t.Helper()
+	c, s := net.Pipe()
+	done := make(chan connResult)
+	go func() {
+		conn, err := Server(s, pwServer, optsServer)
+		done <- connResult{conn, err}
+	}()
+	client.conn, client.err = Client(c, pwClient, optsClient)
+	server = <-done
+	return
+}
+
+func TestConn(t *testing.T) {
+	client, server := connect(t, []byte("password"), []byte("password"),
+		ConnOptions{Curves: []string{"ed25519", "p256"}},
+		ConnOptions{Curves: []string{"p256", "ed25519"}},
+	)
+	if client.err != nil || server.err != nil {
+		t.Fatalf("handshake failed: %v, %v", client.err, server.err)
+	}
+	defer client.conn.Close()
+	defer server.conn.Close()
+
+	// larger than a single frame
+	msg := bytes.Repeat([]byte("hello, world "), 10000)
+	go func() {
+		client.conn.Write(msg)
+		client.conn.Write([]byte("done"))
+	}()
+	got := make([]byte, len(msg)+4)
+	if _, err := io.ReadFull(server.conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append(msg, "done"...)) {
+		t.Error("server received wrong data")
+	}
+
+	go server.conn.Write([]byte("reply"))
+	got = make([]byte, 5)
+	if _, err := io.ReadFull(client.conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "reply" {
+		t.Errorf("client received %q", got)
+	}
+}
+
+func TestConnWrongPassword(t *testing.T) {
+	client, server := connect(t, []byte("password"), []byte("wrong"), ConnOptions{}, ConnOptions{})
+	if client.err != ErrConfirmationFailed {
+		t.Errorf("expected ErrConfirmationFailed, got %v", client.err)
+	}
+	if server.err == nil {
+		t.Error("server should fail the handshake")
+	}
+}
+
+func TestConnTampered(t *testing.T) {
+	client, server := connect(t, []byte("password"), []byte("password"), ConnOptions{}, ConnOptions{})
+	if client.err != nil || server.err != nil {
+		t.Fatalf("handshake failed: %v, %v", client.err, server.err)
+	}
+	defer client.conn.Close()
+	defer server.conn.Close()
+
+	raw := client.conn.(*secureConn).Conn
+	go writeFrame(raw, make([]byte, 32))
+	_, err := server.conn.Read(make([]byte, 32))
+	if err == nil {
+		t.Fatal("server accepted a forged frame")
+	}
+	// the connection stays failed, even for a genuine frame
+	server.conn.SetDeadline(time.Now().Add(time.Second))
+	go client.conn.Write([]byte("hello"))
+	if _, err2 := server.conn.Read(make([]byte, 5)); err2 != err {
+		t.Errorf("Read after the forged frame: got %v, want %v", err2, err)
+	}
+	if _, err2 := server.conn.Write([]byte("hello")); err2 != err {
+		t.Errorf("Write after the forged frame: got %v, want %v", err2, err)
+	}
+}
+
+func TestConnSequenceExhausted(t *testing.T) {
+	client, server := connect(t, []byte("password"), []byte("password"), ConnOptions{}, ConnOptions{})
+	if client.err != nil || server.err != nil {
+		t.Fatalf("handshake failed: %v, %v", client.err, server.err)
+	}
+	defer client.conn.Close()
+	defer server.conn.Close()
+
+	// a nonce must never be reused, so the last sequence number is not
+	// used in either direction
+	server.conn.(*secureConn).readSeq = math.MaxUint64
+	done := make(chan struct{})
+	go func() {
+		client.conn.Write([]byte("hello"))
+		close(done)
+	}()
+	if _, err := server.conn.Read(make([]byte, 5)); !errors.Is(err, ErrSequenceExhausted) {
+		t.Errorf("Read: got %v, want ErrSequenceExhausted", err)
+	}
+	<-done
+	client.conn.(*secureConn).writeSeq = math.MaxUint64
+	if _, err := client.conn.Write([]byte("hello")); !errors.Is(err, ErrSequenceExhausted) {
+		t.Errorf("Write: got %v, want ErrSequenceExhausted", err)
+	}
+}
@@ -0,0 +1,138 @@
+package pake
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+)
+
+// cpaceSuite is the CPace group and hash function of a curve, from
+// draft-irtf-cfrg-cpace.
+type cpaceSuite struct {
+	dsi  string // the domain separation identifier G.DSI
+	hash func() hash.Hash
+}
+
+// cpaceSuites are the CPace suites by curve. The generator is derived
+// from the password, so only ristretto255, whose one-way map is
+// constant time, is supported: the short-Weierstrass suites of the
+// draft need encode_to_curve, and the maps of hashtocurve.go are not
+// constant time.
+var cpaceSuites = map[string]cpaceSuite{
+	"ristretto255": {"CPaceRistretto255", sha512.New},
+}
+
+// initCPace derives the secret generator g from the (stretched)
+// password, the identities and the session ID and, for role 0,
+// computes Ya = ya·g.
+func (p *Pake) initCPace(curve string, pw []byte, opts Options) (err error) {
+	if err = p.useCPaceSuite(curve); err != nil {
+		return
+	}
+	p.sid = opts.SessionID
+	p.adA, p.adB = opts.LocalAssociatedData, opts.RemoteAssociatedData
+	if p.Role == 1 {
+		p.adA, p.adB = p.adB, p.adA
+	}
+	// CI = lv_cat(A, B)
+	ci := lvCat(p.idA, p.idB)
+	H := p.hash()
+	H.Write(cpaceGeneratorString([]byte(cpaceSuites[curve].dsi), pw, ci, p.sid, H.BlockSize()))
+	p.gᵤ, p.gᵥ = ed25519PointToBigInts(ristrettoEncode(ristrettoFromUniformBytes(H.Sum(nil))))
+
+	if p.Role == 0 {
+		// STEP: A computes Ya
+		if p.Aα, err = randomScalar(p.rand, p.order); err != nil {
+			return
+		}
+		p.Xᵤ, p.Xᵥ = scalarMult(p.curve, p.gᵤ, p.gᵥ, p.Aα)
+	}
+	return
+}
+
+// useCPaceSuite selects the hash function of the CPace suite of the
+// curve and clears U and V, which CPace does not use.
+func (p *Pake) useCPaceSuite(curve string) error {
+	suite, ok := cpaceSuites[curve]
+	if !ok {
+		return fmt.Errorf("%w: %q is not supported by CPace", ErrUnknownCurve, curve)
+	}
+	p.hash = suite.hash
+	p.Uᵤ, p.Uᵥ, p.Vᵤ, p.Vᵥ = nil, nil, nil, nil
+	return nil
+}
+
+// cpaceGeneratorString is generator_string of the CPace draft. The
+// zero padding makes DSI and PRS fill the first block of the hash.
+func cpaceGeneratorString(dsi, prs, ci, sid []byte, sInBytes int) []byte {
+	zpad := sInBytes - 1 - len(lvCat(prs)) - len(lvCat(dsi))
+	if zpad < 0 {
+		zpad = 0
+	}
+	return lvCat(dsi, prs, make([]byte, zpad), ci, sid)
+}
+
+// updateCPace runs Update in CPace mode: role 1 answers Ya with
+// Yb = yb·g, and both compute K = y·Y of the other party.
+func (p *Pake) updateCPace(q *Pake) (err error) {
+	if p.Role == 1 {
+		p.Xᵤ, p.Xᵥ = q.Xᵤ, q.Xᵥ
+		if err = p.validatePoint(p.Xᵤ, p.Xᵥ, "X"); err != nil {
+			return
+		}
+		// STEP: B computes Yb and K
+		if p.Aα, err = randomScalar(p.rand, p.order); err != nil {
+			return
+		}
+		p.Yᵤ, p.Yᵥ = scalarMult(p.curve, p.gᵤ, p.gᵥ, p.Aα)
+		p.Zᵤ, p.Zᵥ = scalarMult(p.curve, p.Xᵤ, p.Xᵥ, p.Aα)
+	} else {
+		p.Yᵤ, p.Yᵥ = q.Yᵤ, q.Yᵥ
+		if err = p.validatePoint(p.Yᵤ, p.Yᵥ, "Y"); err != nil {
+			return
+		}
+		// STEP: A computes K
+		p.Zᵤ, p.Zᵥ = scalarMult(p.curve, p.Yᵤ, p.Yᵥ, p.Aα)
+	}
+	// scalar_mult_vfy aborts on the identity
+	if p.Zᵤ.Sign() == 0 && p.Zᵥ.Sign() == 0 {
+		return ErrSmallOrderPoint
+	}
+	p.deriveCPaceKeys()
+	return nil
+}
+
+// deriveCPaceKeys derives the intermediate session key ISK, which
+// becomes the session key, from K and the initiator-responder
+// transcript:
+// ISK = H(lv_cat(DSI || "_ISK", sid, K) || lv_cat(Ya, ADa) || lv_cat(Yb, ADb)).
+// The curves offered by role 0, when the curve is negotiated, are part
+// of ADa.
+func (p *Pake) deriveCPaceKeys() {
+	adA := p.adA
+	if len(p.aad) > 0 {
+		adA = lvCat(p.adA, p.aad)
+	}
+	p.transcript = append(
+		lvCat(p.encodePoint(p.Xᵤ, p.Xᵥ), adA),
+		lvCat(p.encodePoint(p.Yᵤ, p.Yᵥ), p.adB)...,
+	)
+	H := p.hash()
+	H.Write(lvCat([]byte(cpaceSuites[p.Curve].dsi+"_ISK"), p.sid, p.encodePoint(p.Zᵤ, p.Zᵥ)))
+	H.Write(p.transcript)
+	p.K = H.Sum(nil)
+	p.kcA = hmacSum(p.hash, p.K, []byte("pake-v3 CPace confirmation A"))
+	p.kcB = hmacSum(p.hash, p.K, []byte("pake-v3 CPace confirmation B"))
+}
+
+// lvCat is lv_cat of the CPace draft: the fields, each preceded by its
+// length as LEB128.
+func lvCat(fields ...[]byte) []byte {
+	var b []byte
+	for _, f := range fields {
+		b = binary.AppendUvarint(b, uint64(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
@@ -0,0 +1,186 @@
+package pake
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+var cpaceCurves = []string{"ristretto255"}
+
+func TestCPace(t *testing.T) {
+	for _, curve := range cpaceCurves {
+		A, B := exchange(t, curve, []byte("password"), []byte("password"),
+			Options{Mode: ModeCPace, LocalIdentity: "client", RemoteIdentity: "server", SessionID: []byte("sid")},
+			Options{Mode: ModeCPace, LocalIdentity: "server", RemoteIdentity: "client", SessionID: []byte("sid")},
+		)
+		kA, _ := A.SessionKey()
+		kB, _ := B.SessionKey()
+		if !bytes.Equal(kA, kB) {
+			t.Errorf("session keys not equal for %s", curve)
+		}
+		if len(kA) != A.hash().Size() {
+			t.Errorf("ISK should be a full hash output for %s, got %d bytes", curve, len(kA))
+		}
+		cA, _ := A.Confirmation()
+		cB, _ := B.Confirmation()
+		if err := B.VerifyConfirmation(cA); err != nil {
+			t.Errorf("B failed to verify A for %s: %v", curve, err)
+		}
+		if err := A.VerifyConfirmation(cB); err != nil {
+			t.Errorf("A failed to verify B for %s: %v", curve, err)
+		}
+		// the generator is derived from the password and never sent
+		if A.Public().Uᵤ != nil || A.Public().Vᵤ != nil {
+			t.Errorf("%s: CPace messages should not carry U and V", curve)
+		}
+	}
+}
+
+func TestCPaceMismatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		pwA, pwB     []byte
+		optsA, optsB Options
+	}{
+		{"password", []byte("password"), []byte("passw0rd"), Options{}, Options{}},
+		{"identity", []byte("password"), []byte("password"),
+			Options{LocalIdentity: "alice", RemoteIdentity: "bob"},
+			Options{LocalIdentity: "bob", RemoteIdentity: "mallory"}},
+		{"session ID", []byte("password"), []byte("password"),
+			Options{SessionID: []byte("one")},
+			Options{SessionID: []byte("two")}},
+		{"associated data", []byte("password"), []byte("password"),
+			Options{LocalAssociatedData: []byte("ADa"), RemoteAssociatedData: []byte("ADb")},
+			Options{LocalAssociatedData: []byte("ADa"), RemoteAssociatedData: []byte("ADb")}},
+	}
+	for _, curve := range cpaceCurves {
+		for _, tt := range tests {
+			tt.optsA.Mode, tt.optsB.Mode = ModeCPace, ModeCPace
+			A, B := exchange(t, curve, tt.pwA, tt.pwB, tt.optsA, tt.optsB)
+			kA, _ := A.SessionKey()
+			kB, _ := B.SessionKey()
+			if bytes.Equal(kA, kB) {
+				t.Errorf("%s mismatch should produce different keys for %s", tt.name, curve)
+			}
+			cA, _ := A.Confirmation()
+			if err := B.VerifyConfirmation(cA); err != ErrConfirmationFailed {
+				t.Errorf("%s mismatch should fail confirmation for %s, got %v", tt.name, curve, err)
+			}
+		}
+	}
+}
+
+func TestCPaceVectors(t *testing.T) {
+	// draft-irtf-cfrg-cpace, appendix B: the ristretto255 suite, from
+	// the generator to the ISK of the initiator-responder exchange
+	var v struct {
+		Curve, PRS, A, B, CI, SID string
+		GeneratorString           string `json:"generator_string"`
+		HashGeneratorString       string `json:"hash_generator_string"`
+		G                         string `json:"g"`
+		ScalarA                   string `json:"ya"`
+		ADa                       string `json:"ADa"`
+		Ya                        string `json:"Ya"`
+		ScalarB                   string `json:"yb"`
+		ADb                       string `json:"ADb"`
+		Yb                        string `json:"Yb"`
+		K                         string `json:"K"`
+		ISK                       string `json:"ISK_IR"`
+	}
+	readJSON(t, filepath.Join("testdata", "cpace", "CPaceRistretto255-SHA512.json"), &v)
+	if got := hex.EncodeToString(lvCat([]byte(v.A), []byte(v.B))); got != v.CI {
+		t.Errorf("CI is %s, want %s", got, v.CI)
+	}
+	suite := cpaceSuites[v.Curve]
+	genStr := cpaceGeneratorString([]byte(suite.dsi), []byte(v.PRS), mustHex(t, v.CI), mustHex(t, v.SID), suite.hash().BlockSize())
+	if got := hex.EncodeToString(genStr); got != v.GeneratorString {
+		t.Errorf("generator_string is %s, want %s", got, v.GeneratorString)
+	}
+	H := suite.hash()
+	H.Write(genStr)
+	if got := hex.EncodeToString(H.Sum(nil)); got != v.HashGeneratorString {
+		t.Errorf("hash of generator_string is %s, want %s", got, v.HashGeneratorString)
+	}
+
+	// the draft's scalars are little-endian, Rand is read big-endian
+	ya, yb := mustHex(t, v.ScalarA), mustHex(t, v.ScalarB)
+	reverseBytes(ya)
+	reverseBytes(yb)
+	A, B := exchange(t, v.Curve, []byte(v.PRS), []byte(v.PRS), Options{
+		Mode: ModeCPace, LocalIdentity: v.A, RemoteIdentity: v.B, SessionID: mustHex(t, v.SID),
+		LocalAssociatedData: mustHex(t, v.ADa), RemoteAssociatedData: mustHex(t, v.ADb),
+		Rand: vectorReader(ModeCPace, ya),
+	}, Options{
+		Mode: ModeCPace, LocalIdentity: v.B, RemoteIdentity: v.A, SessionID: mustHex(t, v.SID),
+		LocalAssociatedData: mustHex(t, v.ADb), RemoteAssociatedData: mustHex(t, v.ADa),
+		Rand: vectorReader(ModeCPace, yb),
+	})
+	for _, p := range []*Pake{A, B} {
+		for _, c := range []struct {
+			name      string
+			got, want []byte
+		}{
+			{"g", p.encodePoint(p.gᵤ, p.gᵥ), mustHex(t, v.G)},
+			{"Ya", p.encodePoint(p.Xᵤ, p.Xᵥ), mustHex(t, v.Ya)},
+			{"Yb", p.encodePoint(p.Yᵤ, p.Yᵥ), mustHex(t, v.Yb)},
+			{"K", p.encodePoint(p.Zᵤ, p.Zᵥ), mustHex(t, v.K)},
+			{"ISK", p.K, mustHex(t, v.ISK)},
+		} {
+			if !bytes.Equal(c.got, c.want) {
+				t.Errorf("%s of role %d is %x, want %x", c.name, p.Role, c.got, c.want)
+			}
+		}
+	}
+}
+
+func TestCPaceDiffersFromLegacy(t *testing.T) {
+	A, err := InitCurve([]byte{1, 2, 3}, 0, "ristretto255")
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := InitCurveWithOptions([]byte{1, 2, 3}, 1, "ristretto255", Options{Mode: ModeCPace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = B.Update(A.Bytes()); !errors.Is(err, ErrModeMismatch) {
+		t.Errorf("mixing modes should be a mode mismatch, got %v", err)
+	}
+	// legacy peers do not send the mode, the CPace message does
+	if err = A.Update(B.Bytes()); !errors.Is(err, ErrModeMismatch) {
+		t.Errorf("mixing modes should be a mode mismatch, got %v", err)
+	}
+}
+
+func TestCPaceUnsupportedCurve(t *testing.T) {
+	for _, curve := range []string{"p256", "p384", "p521", "siec", "ed25519"} {
+		if _, err := InitCurveWithOptions([]byte{1, 2, 3}, 0, curve, Options{Mode: ModeCPace}); err == nil {
+			t.Errorf("%s should not be supported by CPace", curve)
+		}
+	}
+}
+
+func TestCPaceGeneratorString(t *testing.T) {
+	dsi, prs := []byte("CPaceRistretto255"), []byte("Password")
+	ci, sid := lvCat([]byte("A_initiator"), []byte("B_responder")), []byte{1, 2}
+	s := cpaceGeneratorString(dsi, prs, ci, sid, 128)
+	// DSI, PRS and the padding fill the first block of SHA-512
+	zpad := 128 - 1 - len(lvCat(dsi, prs))
+	want := append(lvCat(dsi, prs), byte(zpad))
+	want = append(want, make([]byte, zpad)...)
+	want = append(want, lvCat(ci, sid)...)
+	if !bytes.Equal(s, want) {
+		t.Errorf("generator string %x, want %x", s, want)
+	}
+	// a long password needs no padding
+	long := bytes.Repeat([]byte{'p'}, 200)
+	if s = cpaceGeneratorString(dsi, long, nil, nil, 128); !bytes.Equal(s, lvCat(dsi, long, nil, nil, nil)) {
+		t.Errorf("generator string %x should have an empty padding", s)
+	}
+	// lengths are LEB128
+	if l := lvCat(long)[:2]; !bytes.Equal(l, []byte{0xc8, 0x01}) {
+		t.Errorf("length of 200 bytes encoded as %x, want c801", l)
+	}
+}
@@ -0,0 +1,260 @@
+package pake
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/tscholl2/siec"
+)
+
+// Point is an affine point of a curve. Edwards25519 and ristretto255
+// keep their 32-byte encoding in X, with Y zero.
+type Point struct {
+	X, Y *big.Int
+}
+
+// CurveSpec describes a curve for RegisterCurve.
+type CurveSpec struct {
+	// Curve implements the group operations. Points are affine
+	// coordinates reduced modulo FieldP with (0, 0) as the identity,
+	// as with crypto/elliptic. Curves on which a point cannot be
+	// negated as (x, -y) must also implement
+	// Subtract(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int).
+	Curve EllipticCurve
+
+	// Order is the prime order of the subgroup used by the exchange.
+	Order *big.Int
+
+	// FieldP is the order of the underlying field.
+	FieldP *big.Int
+
+	// U and V are the points that blind X and Y. They must be in the
+	// subgroup of order Order and nobody may know their discrete
+	// logarithms, e.g. by deriving them from a hash.
+	U, V Point
+}
+
+// curveRegistry holds the built-in and the registered curves. Names
+// keeps the order of AvailableCurves.
+var curveRegistry = struct {
+	sync.RWMutex
+	names  []string
+	specs  map[string]CurveSpec
+	custom map[string]bool
+}{specs: make(map[string]CurveSpec), custom: make(map[string]bool)}
+
+// mustInt parses a decimal constant.
+func mustInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("pake: invalid constant " + s)
+	}
+	return n
+}
+
+// 2^255 - 19
+var ed25519FieldP = mustInt("57896044618658097711785492504343953926634992332820282019728792003956564819949")
+
+func init() {
+	builtin := []struct {
+		name string
+		spec CurveSpec
+	}{
+		{"p521", CurveSpec{
+			Curve:  elliptic.P521(),
+			Order:  elliptic.P521().Params().N,
+			FieldP: elliptic.P521().Params().P,
+			U: Point{
+				mustInt("793136080485469241208656611513609866400481671852"),
+				mustInt("4032821203812196944795502391345776760852202059010382256134592838722123385325802540879231526503456158741518531456199762365161310489884151533417829496019094620"),
+			},
+			V: Point{
+				mustInt("1086685267857089638167386722555472967068468061489"),
+				mustInt("5010916268086655347194655708160715195931018676225831839835602465999566066450501167246678404591906342753230577187831311039273858772817427392089150297708931207"),
+			},
+		}},
+		{"p256", CurveSpec{
+			Curve:  elliptic.P256(),
+			Order:  elliptic.P256().Params().N,
+			FieldP: elliptic.P256().Params().P,
+			U: Point{
+				mustInt("793136080485469241208656611513609866400481671852"),
+				mustInt("59748757929350367369315811184980635230185250460108398961713395032485227207304"),
+			},
+			V: Point{
+				mustInt("1086685267857089638167386722555472967068468061489"),
+				mustInt("9157340230202296554417312816309453883742349874205386245733062928888341584123"),
+			},
+		}},
+		{"p384", CurveSpec{
+			Curve:  elliptic.P384(),
+			Order:  elliptic.P384().Params().N,
+			FieldP: elliptic.P384().Params().P,
+			U: Point{
+				mustInt("793136080485469241208656611513609866400481671852"),
+				mustInt("7854890799382392388170852325516804266858248936799429260403044177981810983054351714387874260245230531084533936948596"),
+			},
+			V: Point{
+				mustInt("1086685267857089638167386722555472967068468061489"),
+				mustInt("21898206562669911998235297167979083576432197282633635629145270958059347586763418294901448537278960988843108277491616"),
+			},
+		}},
+		{"siec", CurveSpec{
+			Curve:  siec.SIEC255(),
+			Order:  siec.SIEC255().N,
+			FieldP: siec.SIEC255().Params().P,
+			U: Point{
+				mustInt("793136080485469241208656611513609866400481671853"),
+				mustInt("18458907634222644275952014841865282643645472623913459400556233196838128612339"),
+			},
+			V: Point{
+				mustInt("1086685267857089638167386722555472967068468061489"),
+				mustInt("19593504966619549205903364028255899745298716108914514072669075231742699650911"),
+			},
+		}},
+		// fixed Edwards25519 points of the prime-order subgroup, whose
+		// derivation is not documented: ed25519_points.py does not
+		// reproduce them
+		{"ed25519", CurveSpec{
+			Curve:  &Edwards25519Curve{},
+			Order:  ed25519Order,
+			FieldP: ed25519FieldP,
+			U:      Point{mustInt("41821174510521985817056358996007359290163947216650231187782646151092828043509"), new(big.Int)},
+			V:      Point{mustInt("1456941786990260824647297143563623381366314063537015067473110401627488371271"), new(big.Int)},
+		}},
+		// elements derived with the one-way map of RFC 9496 from
+		// SHA-512("croc2") and SHA-512("croc1")
+		{"ristretto255", CurveSpec{
+			Curve:  &Ristretto255Curve{},
+			Order:  ed25519Order,
+			FieldP: ed25519FieldP,
+			U:      Point{mustInt("114933119083712932025035857585336992027557819126649805395563394006649522129152"), new(big.Int)},
+			V:      Point{mustInt("18439229684126390624607412934113966270802663424648631005906650238527239277666"), new(big.Int)},
+		}},
+	}
+	for _, c := range builtin {
+		curveRegistry.names = append(curveRegistry.names, c.name)
+		curveRegistry.specs[c.name] = c.spec
+	}
+}
+
+// RegisterCurve makes a curve available under name to InitCurve and
+// the other constructors, in the legacy mode. Both parties must
+// register the same spec under the same name. It fails if the name is
+// taken or if U and V are not distinct points of the subgroup of
+// prime order Order.
+//
+// Registered curves have no identifier in the binary encoding, so
+// their messages must be sent with Bytes.
+func RegisterCurve(name string, spec CurveSpec) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidCurveSpec)
+	}
+	if err := validateCurveSpec(spec); err != nil {
+		return err
+	}
+
+	curveRegistry.Lock()
+	defer curveRegistry.Unlock()
+	if _, ok := curveRegistry.specs[name]; ok {
+		return fmt.Errorf("%w: curve %q already registered", ErrInvalidCurveSpec, name)
+	}
+	spec.Order, spec.FieldP = cloneInt(spec.Order), cloneInt(spec.FieldP)
+	spec.U, spec.V = spec.U.clone(), spec.V.clone()
+	curveRegistry.names = append(curveRegistry.names, name)
+	curveRegistry.specs[name] = spec
+	curveRegistry.custom[name] = true
+	return nil
+}
+
+// validateCurveSpec checks that spec is complete and that U and V are
+// distinct points of the subgroup of order spec.Order.
+func validateCurveSpec(spec CurveSpec) error {
+	if spec.Curve == nil || spec.Order == nil || spec.FieldP == nil {
+		return fmt.Errorf("%w: Curve, Order and FieldP are required", ErrInvalidCurveSpec)
+	}
+	if spec.Order.Cmp(big.NewInt(2)) < 0 || !spec.Order.ProbablyPrime(20) {
+		return fmt.Errorf("%w: Order is not prime", ErrInvalidCurveSpec)
+	}
+	if spec.FieldP.Sign() <= 0 {
+		return fmt.Errorf("%w: FieldP is not positive", ErrInvalidCurveSpec)
+	}
+	for _, pt := range []struct {
+		name string
+		Point
+	}{{"U", spec.U}, {"V", spec.V}} {
+		x, y := pt.X, pt.Y
+		switch {
+		case x == nil || y == nil:
+			return fmt.Errorf("%w: %s values missing", ErrInvalidPoint, pt.name)
+		case x.Sign() < 0 || y.Sign() < 0 || x.Cmp(spec.FieldP) >= 0 || y.Cmp(spec.FieldP) >= 0:
+			return fmt.Errorf("%w: %s values out of range", ErrInvalidPoint, pt.name)
+		case x.Sign() == 0 && y.Sign() == 0:
+			return fmt.Errorf("%w: %s is the identity", ErrSmallOrderPoint, pt.name)
+		case !spec.Curve.IsOnCurve(x, y):
+			return fmt.Errorf("%w: %s values not on curve", ErrInvalidPoint, pt.name)
+		case !inSubgroup(spec.Curve, spec.Order, x, y):
+			return fmt.Errorf("%w: %s is not in the subgroup of order Order", ErrSmallOrderPoint, pt.name)
+		}
+	}
+	if spec.U.X.Cmp(spec.V.X) == 0 && spec.U.Y.Cmp(spec.V.Y) == 0 {
+		return fmt.Errorf("%w: U and V are the same point", ErrInvalidPoint)
+	}
+	return nil
+}
+
+// inSubgroup reports whether order·(x, y) is the identity. It only
+// uses Add, since ScalarMult may reduce the scalar modulo the order of
+// the curve.
+func inSubgroup(curve EllipticCurve, order, x, y *big.Int) bool {
+	rx, ry := new(big.Int), new(big.Int)
+	for i := order.BitLen() - 1; i >= 0; i-- {
+		rx, ry = curve.Add(rx, ry, rx, ry)
+		if order.Bit(i) == 1 {
+			rx, ry = curve.Add(rx, ry, x, y)
+		}
+	}
+	return rx.Sign() == 0 && ry.Sign() == 0
+}
+
+// AvailableCurves returns available curves: the built-in ones followed
+// by the registered ones, in order of registration.
+func AvailableCurves() []string {
+	curveRegistry.RLock()
+	defer curveRegistry.RUnlock()
+	return append([]string(nil), curveRegistry.names...)
+}
+
+// lookupCurve returns the spec of the curve with the given name and
+// whether it was registered with RegisterCurve.
+func lookupCurve(name string) (spec CurveSpec, custom bool, err error) {
+	curveRegistry.RLock()
+	defer curveRegistry.RUnlock()
+	spec, ok := curveRegistry.specs[name]
+	if !ok {
+		return spec, false, fmt.Errorf("%w: %q", ErrUnknownCurve, name)
+	}
+	return spec, curveRegistry.custom[name], nil
+}
+
+// initCurve returns the curve with the given name, the order of its
+// field and its U and V points.
+func initCurve(curve string) (ellipticCurve EllipticCurve, P *big.Int, Ux *big.Int, Uy *big.Int, Vx *big.Int, Vy *big.Int, err error) {
+	spec, _, err := lookupCurve(curve)
+	if err != nil {
+		return
+	}
+	// copies, so that a Pake never shares its points with the registry
+	u, v := spec.U.clone(), spec.V.clone()
+	return spec.Curve, spec.FieldP, u.X, u.Y, v.X, v.Y, nil
+}
+
+func cloneInt(n *big.Int) *big.Int {
+	return new(big.Int).Set(n)
+}
+
+func (pt Point) clone() Point {
+	return Point{cloneInt(pt.X), cloneInt(pt.Y)}
+}
@@ -0,0 +1,127 @@
+package pake
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+)
+
+// unregisterCurve removes a curve registered by a test, so that the
+// other tests only see the built-in curves.
+func unregisterCurve(name string) {
+	curveRegistry.Lock()
+	defer curveRegistry.Unlock()
+	curveRegistry.names = slices.DeleteFunc(curveRegistry.names, func(n string) bool { return n == name })
+	delete(curveRegistry.specs, name)
+	delete(curveRegistry.custom, name)
+}
+
+// p224Spec returns a spec for P-224, which is not built in, with U and
+// V derived from hashes.
+func p224Spec() CurveSpec {
+	c := elliptic.P224()
+	u := sha256.Sum256([]byte("test U"))
+	v := sha256.Sum256([]byte("test V"))
+	Ux, Uy := c.ScalarBaseMult(u[:])
+	Vx, Vy := c.ScalarBaseMult(v[:])
+	return CurveSpec{
+		Curve:  c,
+		Order:  c.Params().N,
+		FieldP: c.Params().P,
+		U:      Point{Ux, Uy},
+		V:      Point{Vx, Vy},
+	}
+}
+
+func TestBuiltinCurveSpecs(t *testing.T) {
+	// the Edwards25519 and ristretto255 encodings have no (0, 0)
+	// identity, their points are checked by their own tests
+	for _, curve := range []string{"p256", "p384", "p521", "siec"} {
+		spec, custom, err := lookupCurve(curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if custom {
+			t.Errorf("%s is not built in", curve)
+		}
+		if err = validateCurveSpec(spec); err != nil {
+			t.Errorf("%s: %v", curve, err)
+		}
+	}
+}
+
+func TestRegisterCurve(t *testing.T) {
+	if err := RegisterCurve("p224", p224Spec()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unregisterCurve("p224") })
+
+	if !slices.Contains(AvailableCurves(), "p224") {
+		t.Errorf("AvailableCurves() = %v, want p224", AvailableCurves())
+	}
+	if err := RegisterCurve("p224", p224Spec()); !errors.Is(err, ErrInvalidCurveSpec) {
+		t.Errorf("registering p224 twice: %v, want ErrInvalidCurveSpec", err)
+	}
+	if err := RegisterCurve("p256", p224Spec()); !errors.Is(err, ErrInvalidCurveSpec) {
+		t.Errorf("registering over p256: %v, want ErrInvalidCurveSpec", err)
+	}
+
+	if !agreed(exchange(t, "p224", []byte("password"), []byte("password"), Options{}, Options{})) {
+		t.Error("same passwords did not agree on p224")
+	}
+	if agreed(exchange(t, "p224", []byte("password"), []byte("wrong"), Options{}, Options{})) {
+		t.Error("different passwords agreed on p224")
+	}
+
+	A, err := InitCurve([]byte("password"), 0, "p224")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = A.MarshalBinary(); !errors.Is(err, ErrUnknownCurve) {
+		t.Errorf("MarshalBinary on p224: %v, want ErrUnknownCurve", err)
+	}
+	if _, err = InitCurveWithOptions([]byte("password"), 0, "p224", Options{Mode: ModeRFC9382}); !errors.Is(err, ErrUnknownCurve) {
+		t.Errorf("RFC 9382 on p224: %v, want ErrUnknownCurve", err)
+	}
+	B, err := InitCurve([]byte("password"), 1, "p224")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = updateWithPoint(B, big.NewInt(0), big.NewInt(0)); !errors.Is(err, ErrSmallOrderPoint) {
+		t.Errorf("identity on p224: %v, want ErrSmallOrderPoint", err)
+	}
+}
+
+func TestRegisterCurveInvalid(t *testing.T) {
+	valid := p224Spec()
+	tests := []struct {
+		name   string
+		modify func(*CurveSpec)
+		want   error
+	}{
+		{"no curve", func(s *CurveSpec) { s.Curve = nil }, ErrInvalidCurveSpec},
+		{"no order", func(s *CurveSpec) { s.Order = nil }, ErrInvalidCurveSpec},
+		{"order not prime", func(s *CurveSpec) { s.Order = big.NewInt(15) }, ErrInvalidCurveSpec},
+		{"no field", func(s *CurveSpec) { s.FieldP = nil }, ErrInvalidCurveSpec},
+		{"U missing", func(s *CurveSpec) { s.U = Point{} }, ErrInvalidPoint},
+		{"V out of range", func(s *CurveSpec) { s.V.X = new(big.Int).Add(s.V.X, s.FieldP) }, ErrInvalidPoint},
+		{"U not on curve", func(s *CurveSpec) { s.U.Y = new(big.Int).Add(s.U.Y, big.NewInt(1)) }, ErrInvalidPoint},
+		{"U identity", func(s *CurveSpec) { s.U = Point{new(big.Int), new(big.Int)} }, ErrSmallOrderPoint},
+		{"wrong subgroup", func(s *CurveSpec) { s.Order = big.NewInt(7) }, ErrSmallOrderPoint},
+		{"U equals V", func(s *CurveSpec) { s.V = s.U }, ErrInvalidPoint},
+	}
+	for _, tt := range tests {
+		spec := valid
+		tt.modify(&spec)
+		if err := RegisterCurve("invalid", spec); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+			unregisterCurve("invalid")
+		}
+	}
+	if err := RegisterCurve("", valid); !errors.Is(err, ErrInvalidCurveSpec) {
+		t.Errorf("empty name: %v, want ErrInvalidCurveSpec", err)
+	}
+}
@@ -0,0 +1,101 @@
+package pake
+
+import "errors"
+
+// The errors returned by this package wrap one of the following
+// sentinel errors with more context, so callers can check them with
+// errors.Is.
+var (
+	// ErrNotInitialized is returned when a method is called on a nil Pake
+	// or Verifier.
+	ErrNotInitialized = errors.New("pake is not initialized")
+
+	// ErrUnknownCurve is returned for a curve that does not exist or is
+	// not supported by the selected mode.
+	ErrUnknownCurve = errors.New("no such curve")
+
+	// ErrInvalidCurveSpec is returned by RegisterCurve for an
+	// incomplete spec or a name that is already taken.
+	ErrInvalidCurveSpec = errors.New("invalid curve spec")
+
+	// ErrUnknownMode is returned for an undefined Mode.
+	ErrUnknownMode = errors.New("no such mode")
+
+	// ErrUnsupportedVersion is returned for a transcript or message
+	// version that this package does not support or did not offer.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+
+	// ErrInvalidCurves is returned by InitNegotiation for an empty list
+	// of curves or a list with duplicates.
+	ErrInvalidCurves = errors.New("invalid list of curves")
+
+	// ErrMalformedMessage is returned when a message of the other party
+	// cannot be decoded.
+	ErrMalformedMessage = errors.New("malformed message")
+
+	// ErrRoleConflict is returned by Update when the other party has the
+	// same role.
+	ErrRoleConflict = errors.New("can't have its own role")
+
+	// ErrInvalidPoint is returned for a point that is missing or not on
+	// the curve.
+	ErrInvalidPoint = errors.New("invalid point")
+
+	// ErrSmallOrderPoint is returned by Update when the other party sends
+	// the identity, a point of small order or, for Edwards25519, a point
+	// outside of the prime-order subgroup.
+	ErrSmallOrderPoint = errors.New("point of small order")
+
+	// ErrCurveMismatch is returned by Update when the other party uses a
+	// different curve or different U and V points, e.g. because it was
+	// initialized with another curve, or when curve negotiation finds no
+	// common curve.
+	ErrCurveMismatch = errors.New("curve mismatch")
+
+	// ErrModeMismatch is returned by Update when the other party uses a
+	// different mode.
+	ErrModeMismatch = errors.New("mode mismatch")
+
+	// ErrNoSessionKey is returned when the session key is needed before
+	// Update has generated it.
+	ErrNoSessionKey = errors.New("session key not generated")
+
+	// ErrConfirmationFailed is returned by VerifyConfirmation when the
+	// other party did not derive the same session key, which usually
+	// means the passwords differ.
+	ErrConfirmationFailed = errors.New("key confirmation failed")
+
+	// ErrInvalidVerifier is returned by InitVerifier for a malformed
+	// Verifier, and by the OPAQUE server for a malformed record or
+	// server key.
+	ErrInvalidVerifier = errors.New("invalid verifier")
+
+	// ErrEnvelopeRecovery is returned by the OPAQUE client when it
+	// cannot open its envelope, which usually means the password is
+	// wrong or the client is not registered.
+	ErrEnvelopeRecovery = errors.New("envelope recovery failed")
+
+	// ErrUnsupportedOption is returned by the OPAQUE functions for an
+	// Options field that OPAQUE does not use, such as Mode or
+	// SessionID, rather than ignoring it.
+	ErrUnsupportedOption = errors.New("unsupported option")
+
+	// ErrInvalidLength is returned by Export and the key derivation
+	// functions for an output length that is negative or larger than
+	// the function can produce.
+	ErrInvalidLength = errors.New("invalid output length")
+
+	// ErrInvalidDST is returned by HashToCurve for an empty domain
+	// separation tag.
+	ErrInvalidDST = errors.New("invalid domain separation tag")
+
+	// ErrSequenceExhausted is returned by the connections of Client and
+	// Server once a direction has used every sequence number, after
+	// which a new exchange is needed.
+	ErrSequenceExhausted = errors.New("sequence number exhausted")
+
+	// ErrInvalidState is returned by RestoreState when the state cannot
+	// be decrypted with the given key, was modified or is not a valid
+	// state.
+	ErrInvalidState = errors.New("invalid state")
+)
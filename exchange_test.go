@@ -0,0 +1,53 @@
+package pake
+
+import (
+	"bytes"
+	"testing"
+)
+
+// exchange initializes role 0 with pwA and optsA and role 1 with pwB
+// and optsB on curve and runs the exchange, failing the test on any
+// error.
+func exchange(t *testing.T, curve string, pwA, pwB []byte, optsA, optsB Options) (A, B *Pake) {
+	t.Helper()
+	A, err := InitCurveWithOptions(pwA, 0, curve, optsA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err = InitCurveWithOptions(pwB, 1, curve, optsB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update(t, A, B)
+	return A, B
+}
+
+// update sends the message of A to B and the answer of B back to A,
+// failing the test on any error.
+func update(t *testing.T, A, B *Pake) {
+	t.Helper()
+	if err := B.Update(A.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := A.Update(B.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// agreed reports whether A and B derived the same session key and B
+// confirmed the key of A.
+func agreed(A, B *Pake) bool {
+	kA, _ := A.SessionKey()
+	kB, _ := B.SessionKey()
+	cA, _ := A.Confirmation()
+	return B.VerifyConfirmation(cA) == nil && bytes.Equal(kA, kB)
+}
+
+// modeCurve returns a curve that supports mode, for the tests that
+// loop over the modes.
+func modeCurve(mode Mode) string {
+	if mode == ModeCPace {
+		return "ristretto255"
+	}
+	return "p256"
+}
@@ -0,0 +1,124 @@
+package pake
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzSeeds returns valid messages of both roles on every curve, in
+// both encodings.
+func fuzzSeeds(f *testing.F) (seeds [][]byte) {
+	for _, curve := range AvailableCurves() {
+		A, err := InitCurve([]byte{1, 2, 3}, 0, curve)
+		if err != nil {
+			f.Fatal(err)
+		}
+		B, err := InitCurve([]byte{1, 2, 3}, 1, curve)
+		if err != nil {
+			f.Fatal(err)
+		}
+		if err = B.Update(A.Bytes()); err != nil {
+			f.Fatal(err)
+		}
+		msgA, _ := A.MarshalBinary()
+		msgB, _ := B.MarshalBinary()
+		seeds = append(seeds, A.Bytes(), B.Bytes(), msgA, msgB)
+	}
+	return append(seeds, []byte("null"), []byte("{}"), []byte(`{"Role":1,"Yᵤ":-1,"Yᵥ":-1}`), nil)
+}
+
+func FuzzUpdate(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		for i := range AvailableCurves() {
+			f.Add(uint8(i), seed)
+		}
+	}
+	f.Fuzz(func(t *testing.T, curveIndex uint8, msg []byte) {
+		curves := AvailableCurves()
+		curve := curves[int(curveIndex)%len(curves)]
+		for role := 0; role < 2; role++ {
+			p, err := InitCurve([]byte{1, 2, 3}, role, curve)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = p.Update(msg); err != nil {
+				continue
+			}
+			if !p.HaveSessionKey() {
+				t.Fatalf("%s role %d: Update succeeded without a session key", curve, role)
+			}
+			if _, err = p.Confirmation(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		q, err := decodeMessage(msg, ModeLegacy)
+		if err != nil {
+			if q != nil {
+				t.Fatal("decodeMessage returned a message and an error")
+			}
+			return
+		}
+		if q == nil || (q.Role != 0 && q.Role != 1) {
+			t.Fatalf("decodeMessage returned an invalid message: %+v", q)
+		}
+		if q.curve == nil {
+			return
+		}
+		// a decoded binary message encodes the same point again
+		b, err := q.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := decodeMessage(b, ModeLegacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Role != q.Role || r.Curve != q.Curve ||
+			!equalPoint(r.Xᵤ, r.Xᵥ, q.Xᵤ, q.Xᵥ) || !equalPoint(r.Yᵤ, r.Yᵥ, q.Yᵤ, q.Yᵥ) {
+			t.Fatalf("binary round trip changed the message: %x -> %x", msg, b)
+		}
+	})
+}
+
+// FuzzSessionKeys checks that both roles derive the same session key
+// from the same arbitrary password, and different keys from different
+// passwords, on every curve.
+func FuzzSessionKeys(f *testing.F) {
+	f.Add([]byte{1, 2, 3}, []byte{1, 2, 3})
+	f.Add([]byte{}, []byte{0})
+	f.Add(make([]byte, 64), append(make([]byte, 63), 1))
+	f.Fuzz(func(t *testing.T, pwA, pwB []byte) {
+		for _, curve := range AvailableCurves() {
+			A, err := InitCurve(pwA, 0, curve)
+			if err != nil {
+				t.Fatal(err)
+			}
+			B, err := InitCurve(pwB, 1, curve)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = B.Update(A.Bytes()); err != nil {
+				t.Fatalf("%s: %v", curve, err)
+			}
+			if err = A.Update(B.Bytes()); err != nil {
+				t.Fatalf("%s: %v", curve, err)
+			}
+			cB, _ := B.Confirmation()
+			err = A.VerifyConfirmation(cB)
+			if bytes.Equal(pwA, pwB) && (err != nil || !bytes.Equal(A.K, B.K)) {
+				t.Errorf("%s: the same password gave different keys", curve)
+			}
+			if !bytes.Equal(pwA, pwB) && err == nil {
+				t.Errorf("%s: different passwords passed key confirmation", curve)
+			}
+		}
+	})
+}
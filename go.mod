@@ -1,6 +1,11 @@
 module github.com/schollz/pake/v3
 
+go 1.21
+
 require (
 	filippo.io/edwards25519 v1.1.0
 	github.com/tscholl2/siec v0.0.0-20240310163802-c2c6f6198406
+	golang.org/x/crypto v0.8.0
 )
+
+require golang.org/x/sys v0.7.0 // indirect
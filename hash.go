@@ -0,0 +1,105 @@
+package pake
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"hash"
+	"math/big"
+)
+
+// hmacSum returns HMAC(key, msg) using the hash function h.
+func hmacSum(h func() hash.Hash, key, msg []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// hkdfExtract implements HKDF-Extract from RFC 5869.
+func hkdfExtract(h func() hash.Hash, salt, ikm []byte) []byte {
+	if salt == nil {
+		salt = make([]byte, h().Size())
+	}
+	return hmacSum(h, salt, ikm)
+}
+
+// hkdfExpand implements HKDF-Expand from RFC 5869.
+func hkdfExpand(h func() hash.Hash, prk, info []byte, length int) ([]byte, error) {
+	size := h().Size()
+	if length < 0 || length > 255*size {
+		return nil, fmt.Errorf("%w: hkdf of %d bytes", ErrInvalidLength, length)
+	}
+	okm := make([]byte, 0, length+size)
+	var t []byte
+	for i := byte(1); len(okm) < length; i++ {
+		mac := hmac.New(h, prk)
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(t[:0])
+		okm = append(okm, t...)
+	}
+	return okm[:length], nil
+}
+
+// hkdf runs HKDF-Extract followed by HKDF-Expand.
+func hkdf(h func() hash.Hash, ikm, salt, info []byte, length int) ([]byte, error) {
+	return hkdfExpand(h, hkdfExtract(h, salt, ikm), info, length)
+}
+
+// expandMessageXMD implements expand_message_xmd from RFC 9380,
+// section 5.3.1. A dst longer than 255 bytes is hashed as in section
+// 5.3.3.
+func expandMessageXMD(h func() hash.Hash, msg, dst []byte, length int) ([]byte, error) {
+	H := h()
+	bInBytes, rInBytes := H.Size(), H.BlockSize()
+	ell := (length + bInBytes - 1) / bInBytes
+	if ell > 255 || length > 65535 {
+		return nil, fmt.Errorf("%w: expand_message_xmd of %d bytes", ErrInvalidLength, length)
+	}
+	if len(dst) > 255 {
+		H.Write([]byte("H2C-OVERSIZE-DST-"))
+		H.Write(dst)
+		dst = H.Sum(nil)
+		H.Reset()
+	}
+	dstPrime := append(append([]byte{}, dst...), byte(len(dst)))
+
+	H.Write(make([]byte, rInBytes))
+	H.Write(msg)
+	H.Write([]byte{byte(length >> 8), byte(length), 0})
+	H.Write(dstPrime)
+	b0 := H.Sum(nil)
+
+	H.Reset()
+	H.Write(b0)
+	H.Write([]byte{1})
+	H.Write(dstPrime)
+	bi := H.Sum(nil)
+	uniform := append(make([]byte, 0, ell*bInBytes), bi...)
+	for i := 2; i <= ell; i++ {
+		H.Reset()
+		for j := range b0 {
+			H.Write([]byte{b0[j] ^ bi[j]})
+		}
+		H.Write([]byte{byte(i)})
+		H.Write(dstPrime)
+		bi = H.Sum(nil)
+		uniform = append(uniform, bi...)
+	}
+	return uniform[:length], nil
+}
+
+// hashToScalar hashes msg to an integer modulo order following
+// hash_to_field from RFC 9380, so that the result is unbiased.
+// The scalar is returned big-endian, padded to the length of order.
+func hashToScalar(h func() hash.Hash, order *big.Int, msg, dst []byte) ([]byte, error) {
+	// L = ceil((ceil(log2(order)) + k) / 8) with k = 128
+	L := (order.BitLen() + 128 + 7) / 8
+	uniform, err := expandMessageXMD(h, msg, dst, L)
+	if err != nil {
+		return nil, err
+	}
+	s := new(big.Int).SetBytes(uniform)
+	s.Mod(s, order)
+	return s.FillBytes(make([]byte, (order.BitLen()+7)/8)), nil
+}
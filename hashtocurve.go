@@ -0,0 +1,356 @@
+package pake
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"filippo.io/edwards25519"
+	"github.com/tscholl2/siec"
+)
+
+// The hash-to-curve suites of RFC 9380 used for each curve. siec has
+// A = 0, which rules out the simplified SWU map, and is not covered by
+// the RFC: suiteSIEC is a suite of this package, named in the style of
+// section 8.10, that combines expand_message_xmd with SHA-256 and the
+// generic Shallue-van de Woestijne map of section 6.6.1. ristretto255
+// follows hash_to_ristretto255 of RFC 9496.
+//
+// The maps use math/big and are not constant time, so they must only
+// be given public inputs: CPace and OPAQUE, which map the password,
+// use ristretto255, whose map works on field.Element.
+const (
+	suiteP256      = "P256_XMD:SHA-256_SSWU_RO_"
+	suiteP384      = "P384_XMD:SHA-384_SSWU_RO_"
+	suiteP521      = "P521_XMD:SHA-512_SSWU_RO_"
+	suiteSIEC      = "siec_XMD:SHA-256_SVDW_RO_"
+	suiteEd25519   = "edwards25519_XMD:SHA-512_ELL2_RO_"
+	suiteRistretto = "ristretto255_XMD:SHA-512_R255MAP_RO_"
+)
+
+// hashToCurveSuites are the suite IDs by curve.
+var hashToCurveSuites = map[string]string{
+	"p256":         suiteP256,
+	"p384":         suiteP384,
+	"p521":         suiteP521,
+	"siec":         suiteSIEC,
+	"ed25519":      suiteEd25519,
+	"ristretto255": suiteRistretto,
+}
+
+// weierstrassSuite hashes to a curve y² = x³ + Ax + B of prime order.
+type weierstrassSuite struct {
+	curve   EllipticCurve
+	p, a, b *big.Int
+	z       *big.Int
+	hash    func() hash.Hash
+	l       int // the length L of hash_to_field
+	svdw    bool
+}
+
+var (
+	// curve25519 in Montgomery form t² = s³ + Js² + s
+	curve25519J = big.NewInt(486662)
+	// sqrt(-486664) with sgn0 0, for the map to edwards25519
+	ed25519MapC1 = func() *big.Int {
+		c := new(big.Int).ModSqrt(new(big.Int).Sub(ed25519FieldP, big.NewInt(486664)), ed25519FieldP)
+		if c.Bit(0) == 1 {
+			c.Sub(ed25519FieldP, c)
+		}
+		return c
+	}()
+)
+
+// weierstrassSuites are the suites of the Weierstrass curves, with Z
+// from RFC 9380, section 8.2, or found with find_z_svdw.
+var weierstrassSuites = map[string]*weierstrassSuite{
+	"p256": nistSuite(elliptic.P256(), -10, sha256.New, 48),
+	"p384": nistSuite(elliptic.P384(), -12, sha512.New384, 72),
+	"p521": nistSuite(elliptic.P521(), -4, sha512.New, 98),
+	"siec": siecSuite(),
+}
+
+func nistSuite(c elliptic.Curve, z int64, h func() hash.Hash, l int) *weierstrassSuite {
+	p := c.Params().P
+	return &weierstrassSuite{
+		curve: c,
+		p:     p,
+		a:     new(big.Int).Sub(p, big.NewInt(3)),
+		b:     c.Params().B,
+		z:     new(big.Int).Mod(big.NewInt(z), p),
+		hash:  h,
+		l:     l,
+	}
+}
+
+func siecSuite() *weierstrassSuite {
+	c := siec.SIEC255()
+	s := &weierstrassSuite{
+		curve: c,
+		p:     c.P,
+		a:     new(big.Int).Mod(c.A, c.P),
+		b:     new(big.Int).Mod(c.B, c.P),
+		hash:  sha256.New,
+		l:     48,
+		svdw:  true,
+	}
+	s.z = s.findZSvdW()
+	return s
+}
+
+// HashToCurve hashes msg to a point of the prime-order group of a
+// built-in curve with hash_to_curve of RFC 9380, using the suite of
+// the curve and the domain separation tag dst, which must not be
+// empty. siec has no suite in the RFC and uses one of this package.
+// Nobody knows the discrete logarithm of the point, e.g. with respect
+// to the generator. Except for ristretto255, the maps are not constant
+// time, so msg must not be a secret such as a password.
+//
+// As with the other functions, ed25519 and ristretto255 points are
+// returned as their 32-byte encoding in X, with Y zero.
+func HashToCurve(curve string, msg, dst []byte) (Point, error) {
+	if len(dst) == 0 {
+		return Point{}, fmt.Errorf("%w: empty", ErrInvalidDST)
+	}
+	if s, ok := weierstrassSuites[curve]; ok {
+		u, err := hashToField(s.hash, s.p, msg, dst, 2, s.l)
+		if err != nil {
+			return Point{}, err
+		}
+		x0, y0 := s.mapToCurve(u[0])
+		x1, y1 := s.mapToCurve(u[1])
+		x, y := s.curve.Add(x0, y0, x1, y1)
+		return Point{x, y}, nil
+	}
+	switch curve {
+	case "ed25519":
+		u, err := hashToField(sha512.New, ed25519FieldP, msg, dst, 2, 48)
+		if err != nil {
+			return Point{}, err
+		}
+		q := mapToEdwards25519(u[0])
+		q.Add(q, mapToEdwards25519(u[1]))
+		q.MultByCofactor(q)
+		x, y := ed25519PointToBigInts(q.Bytes())
+		return Point{x, y}, nil
+	case "ristretto255":
+		uniform, err := expandMessageXMD(sha512.New, msg, dst, 64)
+		if err != nil {
+			return Point{}, err
+		}
+		x, y := ed25519PointToBigInts(ristrettoEncode(ristrettoFromUniformBytes(uniform)))
+		return Point{x, y}, nil
+	}
+	return Point{}, fmt.Errorf("%w: no hash-to-curve suite for %q", ErrUnknownCurve, curve)
+}
+
+// hashToField implements hash_to_field of RFC 9380, section 5.2, for
+// a prime field.
+func hashToField(h func() hash.Hash, p *big.Int, msg, dst []byte, count, l int) ([]*big.Int, error) {
+	uniform, err := expandMessageXMD(h, msg, dst, count*l)
+	if err != nil {
+		return nil, err
+	}
+	u := make([]*big.Int, count)
+	for i := range u {
+		u[i] = new(big.Int).SetBytes(uniform[i*l : (i+1)*l])
+		u[i].Mod(u[i], p)
+	}
+	return u, nil
+}
+
+// GeneratePoints derives one point per seed on a built-in curve with
+// the hash-to-curve suite of the curve, using the domain separation
+// tag "pake-v3 point generation " followed by the suite ID. Nobody
+// knows the discrete logarithms of the points, so they can serve as U
+// and V, e.g. for RegisterCurve or to audit new constants.
+//
+// The hard-coded U and V points of the legacy mode predate this
+// function and are not its output for the seeds "croc2" and "croc1":
+// they come from the SHA-1 search described in the README, and
+// changing them would break every existing peer.
+func GeneratePoints(curve string, seeds ...string) ([]Point, error) {
+	suite, ok := hashToCurveSuites[curve]
+	if !ok {
+		return nil, fmt.Errorf("%w: no hash-to-curve suite for %q", ErrUnknownCurve, curve)
+	}
+	dst := []byte("pake-v3 point generation " + suite)
+	points := make([]Point, len(seeds))
+	for i, seed := range seeds {
+		pt, err := HashToCurve(curve, []byte(seed), dst)
+		if err != nil {
+			return nil, err
+		}
+		points[i] = pt
+	}
+	return points, nil
+}
+
+// Field arithmetic modulo the field order of s.
+
+func (s *weierstrassSuite) mod(x *big.Int) *big.Int { return x.Mod(x, s.p) }
+
+func (s *weierstrassSuite) mul(x, y *big.Int) *big.Int { return s.mod(new(big.Int).Mul(x, y)) }
+
+func (s *weierstrassSuite) add(x, y *big.Int) *big.Int { return s.mod(new(big.Int).Add(x, y)) }
+
+func (s *weierstrassSuite) sub(x, y *big.Int) *big.Int { return s.mod(new(big.Int).Sub(x, y)) }
+
+func (s *weierstrassSuite) neg(x *big.Int) *big.Int { return s.mod(new(big.Int).Neg(x)) }
+
+// inv0 returns the inverse of x, or 0 for x = 0.
+func (s *weierstrassSuite) inv0(x *big.Int) *big.Int {
+	if x.Sign() == 0 {
+		return new(big.Int)
+	}
+	return new(big.Int).ModInverse(x, s.p)
+}
+
+func (s *weierstrassSuite) isSquare(x *big.Int) bool {
+	return x.Sign() == 0 || big.Jacobi(x, s.p) == 1
+}
+
+func (s *weierstrassSuite) sqrt(x *big.Int) *big.Int {
+	return new(big.Int).ModSqrt(x, s.p)
+}
+
+// g returns x³ + Ax + B.
+func (s *weierstrassSuite) g(x *big.Int) *big.Int {
+	return s.add(s.mul(s.add(s.mul(x, x), s.a), x), s.b)
+}
+
+func (s *weierstrassSuite) mapToCurve(u *big.Int) (x, y *big.Int) {
+	if s.svdw {
+		x, y = s.mapSvdW(u)
+	} else {
+		x, y = s.mapSSWU(u)
+	}
+	// sgn0 of a prime field element is its parity
+	if u.Bit(0) != y.Bit(0) {
+		y = s.neg(y)
+	}
+	return
+}
+
+// mapSSWU is the simplified SWU map of RFC 9380, section 6.6.2,
+// before the sign of y is fixed.
+func (s *weierstrassSuite) mapSSWU(u *big.Int) (x, y *big.Int) {
+	zu2 := s.mul(s.z, s.mul(u, u))
+	// tv1 = inv0(Z² u⁴ + Z u²)
+	tv1 := s.inv0(s.add(s.mul(zu2, zu2), zu2))
+	var x1 *big.Int
+	if tv1.Sign() == 0 {
+		// x1 = B / (Z A)
+		x1 = s.mul(s.b, s.inv0(s.mul(s.z, s.a)))
+	} else {
+		// x1 = (-B / A) (1 + tv1)
+		x1 = s.mul(s.mul(s.neg(s.b), s.inv0(s.a)), s.add(big.NewInt(1), tv1))
+	}
+	if gx1 := s.g(x1); s.isSquare(gx1) {
+		return x1, s.sqrt(gx1)
+	}
+	x2 := s.mul(zu2, x1)
+	return x2, s.sqrt(s.g(x2))
+}
+
+// mapSvdW is the Shallue-van de Woestijne map of RFC 9380,
+// section 6.6.1, before the sign of y is fixed.
+func (s *weierstrassSuite) mapSvdW(u *big.Int) (x, y *big.Int) {
+	gz := s.g(s.z)
+	// 3Z² + 4A
+	t := s.add(s.mul(big.NewInt(3), s.mul(s.z, s.z)), s.mul(big.NewInt(4), s.a))
+	c1 := gz
+	c2 := s.mul(s.neg(s.z), s.inv0(big.NewInt(2)))
+	c3 := s.sqrt(s.neg(s.mul(gz, t)))
+	if c3.Bit(0) == 1 {
+		c3 = s.neg(c3)
+	}
+	c4 := s.mul(s.neg(s.mul(big.NewInt(4), gz)), s.inv0(t))
+
+	tv1 := s.mul(s.mul(u, u), c1)
+	tv2 := s.add(big.NewInt(1), tv1)
+	tv1 = s.sub(big.NewInt(1), tv1)
+	tv3 := s.inv0(s.mul(tv1, tv2))
+	tv4 := s.mul(s.mul(s.mul(u, tv1), tv3), c3)
+	if x1 := s.sub(c2, tv4); s.isSquare(s.g(x1)) {
+		return x1, s.sqrt(s.g(x1))
+	}
+	if x2 := s.add(c2, tv4); s.isSquare(s.g(x2)) {
+		return x2, s.sqrt(s.g(x2))
+	}
+	x3 := s.mul(tv2, tv2)
+	x3 = s.mul(x3, tv3)
+	x3 = s.mul(x3, x3)
+	x3 = s.add(s.mul(x3, c4), s.z)
+	return x3, s.sqrt(s.g(x3))
+}
+
+// findZSvdW implements find_z_svdw of RFC 9380, appendix H.1.
+func (s *weierstrassSuite) findZSvdW() *big.Int {
+	h := func(z *big.Int) *big.Int {
+		t := s.add(s.mul(big.NewInt(3), s.mul(z, z)), s.mul(big.NewInt(4), s.a))
+		return s.mul(s.neg(t), s.inv0(s.mul(big.NewInt(4), s.g(z))))
+	}
+	for ctr := int64(1); ; ctr++ {
+		for _, z := range []*big.Int{s.mod(big.NewInt(ctr)), s.mod(big.NewInt(-ctr))} {
+			if s.g(z).Sign() == 0 || h(z).Sign() == 0 || !s.isSquare(h(z)) {
+				continue
+			}
+			if s.isSquare(s.g(z)) || s.isSquare(s.g(s.mul(s.neg(z), s.inv0(big.NewInt(2))))) {
+				return z
+			}
+		}
+	}
+}
+
+// mapToEdwards25519 is the Elligator 2 map to curve25519 of RFC 9380,
+// section 6.7.1, followed by the rational map to edwards25519 of
+// appendix D. The cofactor is not cleared.
+func mapToEdwards25519(u *big.Int) *edwards25519.Point {
+	f := &weierstrassSuite{p: ed25519FieldP}
+	one := big.NewInt(1)
+	// x1 = -J / (1 + 2u²), or -J if the denominator is 0
+	x1 := f.mul(f.neg(curve25519J), f.inv0(f.add(one, f.mul(big.NewInt(2), f.mul(u, u)))))
+	if x1.Sign() == 0 {
+		x1 = f.neg(curve25519J)
+	}
+	gx := func(x *big.Int) *big.Int {
+		// x³ + Jx² + x
+		return f.add(f.mul(f.add(f.mul(x, x), f.mul(curve25519J, x)), x), x)
+	}
+	var s, t *big.Int
+	if gx1 := gx(x1); f.isSquare(gx1) {
+		s, t = x1, f.sqrt(gx1)
+		if t.Bit(0) == 0 {
+			t = f.neg(t)
+		}
+	} else {
+		s = f.sub(f.neg(x1), curve25519J)
+		t = f.sqrt(gx(s))
+		if t.Bit(0) == 1 {
+			t = f.neg(t)
+		}
+	}
+
+	// (v, w) = (sqrt(-486664) s / t, (s - 1) / (s + 1)), or the
+	// identity for t = 0 or s = -1
+	sPlus1 := f.add(s, one)
+	if t.Sign() == 0 || sPlus1.Sign() == 0 {
+		return edwards25519.NewIdentityPoint()
+	}
+	v := f.mul(f.mul(ed25519MapC1, s), f.inv0(t))
+	w := f.mul(f.sub(s, one), f.inv0(sPlus1))
+
+	// the encoding is w in little-endian with the sign of v on top
+	b := w.FillBytes(make([]byte, 32))
+	reverseBytes(b)
+	b[31] |= byte(v.Bit(0) << 7)
+	q, err := new(edwards25519.Point).SetBytes(b)
+	if err != nil {
+		panic("pake: Elligator 2 produced an invalid point")
+	}
+	return q
+}
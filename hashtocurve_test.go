@@ -0,0 +1,290 @@
+package pake
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func hexInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex " + s)
+	}
+	return n
+}
+
+func hashToCurvePath(name string) string {
+	return filepath.Join("testdata", "hashtocurve", name+".json")
+}
+
+func readJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestExpandMessageXMD(t *testing.T) {
+	// RFC 9380, appendix K.1 and K.3
+	hashes := map[string]func() hash.Hash{"SHA256": sha256.New, "SHA512": sha512.New}
+	for _, name := range []string{"expand_message_xmd_SHA256_38", "expand_message_xmd_SHA256_256", "expand_message_xmd_SHA512_38"} {
+		var suite struct {
+			DST   string
+			Hash  string
+			Tests []struct {
+				Len    string `json:"len_in_bytes"`
+				Msg    string
+				Output string `json:"uniform_bytes"`
+			}
+		}
+		readJSON(t, hashToCurvePath(name), &suite)
+		for _, tt := range suite.Tests {
+			uniform, err := expandMessageXMD(hashes[suite.Hash], []byte(tt.Msg), []byte(suite.DST), int(hexInt(tt.Len[2:]).Int64()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(uniform); got != tt.Output {
+				t.Errorf("%s(%q, %s) = %s, want %s", name, tt.Msg, tt.Len, got, tt.Output)
+			}
+		}
+	}
+}
+
+// h2cPoint is an affine point of the RFC 9380 vectors.
+type h2cPoint struct {
+	X, Y string
+}
+
+// point returns p in the representation of the curve: affine
+// coordinates, or the 32-byte encoding in X for ed25519.
+func (p h2cPoint) point(curve string) Point {
+	x, y := hexInt(p.X[2:]), hexInt(p.Y[2:])
+	if curve != "ed25519" {
+		return Point{x, y}
+	}
+	// the encoding is y with the sign of x on top
+	b := y.FillBytes(make([]byte, 32))
+	reverseBytes(b)
+	b[31] |= byte(x.Bit(0) << 7)
+	x, y = ed25519PointToBigInts(b)
+	return Point{x, y}
+}
+
+func TestHashToCurve(t *testing.T) {
+	// RFC 9380, appendix J
+	files := map[string]string{
+		"p256":    "P256_XMD-SHA-256_SSWU_RO_",
+		"p384":    "P384_XMD-SHA-384_SSWU_RO_",
+		"p521":    "P521_XMD-SHA-512_SSWU_RO_",
+		"ed25519": "edwards25519_XMD-SHA-512_ELL2_RO_",
+	}
+	for curve, name := range files {
+		var suite struct {
+			Ciphersuite string
+			DST         string
+			Vectors     []struct {
+				Msg    string
+				U      []string
+				Q0, Q1 h2cPoint
+				P      h2cPoint
+			}
+		}
+		readJSON(t, hashToCurvePath(name), &suite)
+		if suite.Ciphersuite != hashToCurveSuites[curve] {
+			t.Fatalf("%s: suite %s, want %s", curve, hashToCurveSuites[curve], suite.Ciphersuite)
+		}
+		for _, tt := range suite.Vectors {
+			pt, err := HashToCurve(curve, []byte(tt.Msg), []byte(suite.DST))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.P.point(curve); pt.X.Cmp(want.X) != 0 || pt.Y.Cmp(want.Y) != 0 {
+				t.Errorf("%s(%q) = (%x, %x), want (%x, %x)", suite.Ciphersuite, tt.Msg, pt.X, pt.Y, want.X, want.Y)
+			}
+
+			// the intermediate values: u = hash_to_field(msg, 2) and
+			// Q0, Q1 = map_to_curve(u)
+			var u []*big.Int
+			if s, ok := weierstrassSuites[curve]; ok {
+				u, _ = hashToField(s.hash, s.p, []byte(tt.Msg), []byte(suite.DST), 2, s.l)
+			} else {
+				u, _ = hashToField(sha512.New, ed25519FieldP, []byte(tt.Msg), []byte(suite.DST), 2, 48)
+			}
+			for i, q := range []h2cPoint{tt.Q0, tt.Q1} {
+				if u[i].Cmp(hexInt(tt.U[i][2:])) != 0 {
+					t.Errorf("%s(%q): u[%d] = %x, want %s", suite.Ciphersuite, tt.Msg, i, u[i], tt.U[i])
+				}
+				var got Point
+				if s, ok := weierstrassSuites[curve]; ok {
+					got.X, got.Y = s.mapToCurve(u[i])
+				} else {
+					got.X, got.Y = ed25519PointToBigInts(mapToEdwards25519(u[i]).Bytes())
+				}
+				if want := q.point(curve); got.X.Cmp(want.X) != 0 || got.Y.Cmp(want.Y) != 0 {
+					t.Errorf("%s(%q): Q%d = (%x, %x), want (%x, %x)", suite.Ciphersuite, tt.Msg, i, got.X, got.Y, want.X, want.Y)
+				}
+			}
+		}
+	}
+}
+
+func TestHashToCurveRistretto255(t *testing.T) {
+	// RFC 9496 has no vectors for hash_to_ristretto255, so this uses
+	// the OPRF vectors of RFC 9497, appendix A.1, where the blinded
+	// element is Blind * HashToGroup(Input)
+	var suites []struct {
+		GroupDST string
+		Mode     int
+		Vectors  []struct {
+			Blind, BlindedElement, Input string
+		}
+	}
+	readJSON(t, hashToCurvePath("oprf_ristretto255-SHA512"), &suites)
+	curve := &Ristretto255Curve{}
+	for _, suite := range suites {
+		for _, tt := range suite.Vectors {
+			// batched vectors are comma separated
+			blinds := strings.Split(tt.Blind, ",")
+			elements := strings.Split(tt.BlindedElement, ",")
+			for i, input := range strings.Split(tt.Input, ",") {
+				pt, err := HashToCurve("ristretto255", mustHex(t, input), mustHex(t, suite.GroupDST))
+				if err != nil {
+					t.Fatal(err)
+				}
+				// ScalarMult reads the scalar big-endian
+				blind := mustHex(t, blinds[i])
+				reverseBytes(blind)
+				x, _ := curve.ScalarMult(pt.X, pt.Y, blind)
+				want, _ := ed25519PointToBigInts(mustHex(t, elements[i]))
+				if x.Cmp(want) != 0 {
+					t.Errorf("mode %d, input %s: blinded element %x, want %s", suite.Mode, input, x, elements[i])
+				}
+			}
+		}
+	}
+}
+
+func TestHashToCurveErrors(t *testing.T) {
+	if _, err := HashToCurve("p256", []byte("msg"), nil); !errors.Is(err, ErrInvalidDST) {
+		t.Errorf("HashToCurve should reject an empty DST, got %v", err)
+	}
+	if _, err := HashToCurve("p224", []byte("msg"), []byte("dst")); !errors.Is(err, ErrUnknownCurve) {
+		t.Errorf("HashToCurve on p224: %v, want ErrUnknownCurve", err)
+	}
+	// siec has no suite in RFC 9380, so only check that the points
+	// are in the group and depend on the message
+	spec, _, _ := lookupCurve("siec")
+	a, _ := HashToCurve("siec", []byte("a"), []byte("dst"))
+	b, _ := HashToCurve("siec", []byte("b"), []byte("dst"))
+	if !inSubgroup(spec.Curve, spec.Order, a.X, a.Y) || a.X.Cmp(b.X) == 0 {
+		t.Error("siec: invalid hash to curve")
+	}
+}
+
+func TestGeneratePoints(t *testing.T) {
+	for _, curve := range AvailableCurves() {
+		points, err := GeneratePoints(curve, "croc2", "croc1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := GeneratePoints(curve, "croc2")
+		if points[0].X.Cmp(again[0].X) != 0 || points[0].Y.Cmp(again[0].Y) != 0 {
+			t.Errorf("%s: GeneratePoints is not deterministic", curve)
+		}
+		spec, _, _ := lookupCurve(curve)
+		spec.U, spec.V = points[0], points[1]
+		switch curve {
+		case "ed25519":
+			ed := &Edwards25519Curve{}
+			for _, pt := range points {
+				if !ed.inPrimeOrderSubgroup(pt.X, pt.Y) {
+					t.Errorf("%s: generated point not in the prime-order subgroup", curve)
+				}
+			}
+		case "ristretto255":
+			for _, pt := range points {
+				if !spec.Curve.IsOnCurve(pt.X, pt.Y) {
+					t.Errorf("%s: generated point not on curve", curve)
+				}
+			}
+		default:
+			if err = validateCurveSpec(spec); err != nil {
+				t.Errorf("%s: %v", curve, err)
+			}
+		}
+	}
+	for _, seeds := range [][]string{{"croc2"}, nil} {
+		if _, err := GeneratePoints("p224", seeds...); !errors.Is(err, ErrUnknownCurve) {
+			t.Errorf("GeneratePoints with %d seeds should fail with ErrUnknownCurve for a curve without a suite, got %v", len(seeds), err)
+		}
+	}
+}
+
+// findPoint is find_point of the Sage script in the README: the
+// little-endian SHA-1 of the seed is incremented until it is the x
+// coordinate of a point.
+func findPoint(s *weierstrassSuite, seed string) *big.Int {
+	h := sha1.Sum([]byte(seed))
+	le := h[:]
+	slices.Reverse(le)
+	x := new(big.Int).SetBytes(le)
+	for !s.isSquare(s.g(x)) {
+		x.Add(x, big.NewInt(1))
+	}
+	return x
+}
+
+// TestLegacyPoints checks the hard-coded U and V of the Weierstrass
+// curves against their documented derivation from the seeds "croc2"
+// and "croc1", the SHA-1 search of the README. Sage picks either
+// square root for y, so y is only checked by the curve equation. The
+// ristretto255 points are checked by TestRistrettoPoints. The ed25519
+// points are not reproduced by ed25519_points.py, so only their
+// subgroup is checked.
+func TestLegacyPoints(t *testing.T) {
+	for _, curve := range []string{"p256", "p384", "p521", "siec"} {
+		s := weierstrassSuites[curve]
+		spec, _, _ := lookupCurve(curve)
+		for _, pt := range []struct {
+			seed string
+			Point
+		}{{"croc2", spec.U}, {"croc1", spec.V}} {
+			if x := findPoint(s, pt.seed); pt.X.Cmp(x) != 0 {
+				t.Errorf("%s: x for %s is %d, want %d", curve, pt.seed, pt.X, x)
+			}
+			if !spec.Curve.IsOnCurve(pt.X, pt.Y) {
+				t.Errorf("%s: point for %s not on curve", curve, pt.seed)
+			}
+		}
+	}
+	spec, _, _ := lookupCurve("ed25519")
+	for _, pt := range []Point{spec.U, spec.V} {
+		if !(&Edwards25519Curve{}).inPrimeOrderSubgroup(pt.X, pt.Y) {
+			t.Errorf("ed25519: %d not in the prime-order subgroup", pt.X)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package pake
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Offer is a curve proposed by role 0 during curve negotiation,
+// together with the point X computed on that curve.
+type Offer struct {
+	Curve  string
+	Xᵤ, Xᵥ *big.Int
+}
+
+// negotiation is the state of a Pake that has not yet settled on a
+// curve: one initialized Pake per curve it supports.
+type negotiation struct {
+	candidates map[string]*Pake
+}
+
+// InitNegotiation initializes a Pake that negotiates the curve instead
+// of using a fixed one. Role 0 offers curves, in order of preference,
+// and role 1 picks the first offered curve that is also in its own
+// curves. The exchange then continues with Update exactly as with
+// InitCurve.
+//
+// The offered list is bound into the session and confirmation keys,
+// so an attacker removing curves from the offer to force a weaker
+// curve makes key confirmation fail.
+func InitNegotiation(pw []byte, role int, curves []string, opts Options) (p *Pake, err error) {
+	if len(curves) == 0 {
+		return nil, fmt.Errorf("%w: no curves to negotiate", ErrInvalidCurves)
+	}
+	n := &negotiation{candidates: make(map[string]*Pake)}
+	stretched := stretchPassword(pw, opts)
+	for _, curve := range curves {
+		if _, ok := n.candidates[curve]; ok {
+			return nil, fmt.Errorf("%w: duplicate curve %q", ErrInvalidCurves, curve)
+		}
+		var c *Pake
+		c, err = initPassword(pw, stretched, role, curve, opts)
+		if err != nil {
+			return nil, err
+		}
+		n.candidates[curve] = c
+	}
+	p = &Pake{Mode: opts.Mode, negotiation: n}
+	if role == 1 {
+		p.Role = 1
+		return
+	}
+	p.Version = n.candidates[curves[0]].Version
+	for _, curve := range curves {
+		c := n.candidates[curve]
+		p.Offers = append(p.Offers, Offer{Curve: curve, Xᵤ: c.Xᵤ, Xᵥ: c.Xᵥ})
+	}
+	return
+}
+
+// negotiate settles p on the curve of the other party's message q.
+// Role 1 picks the curve from the offers in q and takes the offered X
+// as the X of q, role 0 switches to the candidate for the curve chosen
+// by role 1.
+func (p *Pake) negotiate(q *Pake) error {
+	n := p.negotiation
+	if p.Role == 0 {
+		c, ok := n.candidates[q.Curve]
+		if !ok {
+			return ErrCurveMismatch
+		}
+		c.aad = encodeOffers(p.Offers)
+		*p = *c
+		return nil
+	}
+
+	for _, offer := range q.Offers {
+		c, ok := n.candidates[offer.Curve]
+		if !ok {
+			continue
+		}
+		c.aad = encodeOffers(q.Offers)
+		*p = *c
+		q.Curve = offer.Curve
+		q.Xᵤ, q.Xᵥ = offer.Xᵤ, offer.Xᵥ
+		return nil
+	}
+	return ErrCurveMismatch
+}
+
+// encodeOffers encodes the names of the offered curves, in order, for
+// binding them into the keys.
+func encodeOffers(offers []Offer) []byte {
+	b := appendLengthPrefixed(nil, []byte("pake-v3 curves"))
+	for _, offer := range offers {
+		b = appendLengthPrefixed(b, []byte(offer.Curve))
+	}
+	return b
+}
@@ -0,0 +1,125 @@
+package pake
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func negotiate(t *testing.T, A, B *Pake, msgA []byte) {
+	t.Helper()
+	if err := B.Update(msgA); err != nil {
+		t.Fatal(err)
+	}
+	if err := A.Update(B.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	tests := []struct {
+		curvesA, curvesB []string
+		mode             Mode
+		want             string
+	}{
+		{[]string{"p521", "p256"}, []string{"p256", "ed25519"}, ModeLegacy, "p256"},
+		{[]string{"ed25519", "p256"}, []string{"p256", "ed25519"}, ModeLegacy, "ed25519"},
+		{[]string{"ristretto255", "siec"}, []string{"siec", "ristretto255"}, ModeLegacy, "ristretto255"},
+		{[]string{"p384", "p256"}, []string{"p256", "p384"}, ModeRFC9382, "p384"},
+		{[]string{"p521", "ed25519"}, []string{"ed25519"}, ModeSPAKE2Plus, "ed25519"},
+		{[]string{"ristretto255"}, []string{"ristretto255"}, ModeCPace, "ristretto255"},
+	}
+	for _, tt := range tests {
+		A, err := InitNegotiation([]byte("password"), 0, tt.curvesA, Options{Mode: tt.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err := InitNegotiation([]byte("password"), 1, tt.curvesB, Options{Mode: tt.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		negotiate(t, A, B, A.Bytes())
+		if A.Curve != tt.want || B.Curve != tt.want {
+			t.Errorf("negotiated %s and %s, want %s", A.Curve, B.Curve, tt.want)
+		}
+		kA, _ := A.SessionKey()
+		kB, _ := B.SessionKey()
+		if !bytes.Equal(kA, kB) {
+			t.Errorf("session keys not equal for %s", tt.want)
+		}
+		cA, _ := A.Confirmation()
+		if err = B.VerifyConfirmation(cA); err != nil {
+			t.Errorf("confirmation failed for %s: %v", tt.want, err)
+		}
+	}
+}
+
+func TestNegotiationNoCommonCurve(t *testing.T) {
+	A, err := InitNegotiation([]byte("password"), 0, []string{"p521"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := InitNegotiation([]byte("password"), 1, []string{"p256"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = B.Update(A.Bytes()); err != ErrCurveMismatch {
+		t.Errorf("expected ErrCurveMismatch, got %v", err)
+	}
+	// a peer with a fixed curve cannot answer an offer
+	C, err := InitCurve([]byte("password"), 1, "p521")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = C.Update(A.Bytes()); err == nil {
+		t.Error("a fixed curve peer should reject an offer")
+	}
+}
+
+func TestNegotiationDowngrade(t *testing.T) {
+	// CPace supports a single curve, so there is nothing to downgrade to
+	for _, mode := range []Mode{ModeLegacy, ModeRFC9382, ModeSPAKE2Plus} {
+		A, err := InitNegotiation([]byte("password"), 0, []string{"p521", "p256"}, Options{Mode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err := InitNegotiation([]byte("password"), 1, []string{"p256", "p521"}, Options{Mode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// an attacker strips the strongest curve from the offer
+		q := A.Public()
+		q.Offers = q.Offers[1:]
+		msgA, err := json.Marshal(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		negotiate(t, A, B, msgA)
+		if B.Curve != "p256" {
+			t.Fatalf("expected the downgrade to p256, got %s", B.Curve)
+		}
+		cB, _ := B.Confirmation()
+		if err = A.VerifyConfirmation(cB); err != ErrConfirmationFailed {
+			t.Errorf("mode %d: the downgrade was not detected: %v", mode, err)
+		}
+	}
+}
+
+func TestNegotiationInvalid(t *testing.T) {
+	if _, err := InitNegotiation([]byte("password"), 0, nil, Options{}); err == nil {
+		t.Error("an empty list of curves should fail")
+	}
+	if _, err := InitNegotiation([]byte("password"), 0, []string{"p256", "p256"}, Options{}); err == nil {
+		t.Error("duplicate curves should fail")
+	}
+	if _, err := InitNegotiation([]byte("password"), 0, []string{"p256", "siec"}, Options{Mode: ModeRFC9382}); err == nil {
+		t.Error("curves unsupported by the mode should fail")
+	}
+	A, err := InitNegotiation([]byte("password"), 0, []string{"p256"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = A.MarshalBinary(); err == nil {
+		t.Error("an offer cannot be encoded in binary")
+	}
+}
@@ -0,0 +1,776 @@
+package pake
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// OPAQUE is the augmented PAKE of RFC 9807 with the 3DH key exchange:
+// the server stores a record per client from which the password cannot
+// be recovered without a dictionary attack, and never sees the
+// password, not even at registration. It is only available on
+// ristretto255, with the ristretto255-SHA512 OPRF ciphersuite of
+// RFC 9497.
+//
+// Registration:
+//
+//	client: NewOpaqueRegistration      -> request
+//	server: OpaqueServer.RegistrationResponse(request) -> response
+//	client: OpaqueRegistration.Finish(response) -> upload, export key
+//	server: OpaqueServer.NewRecord(upload) -> record to store
+//
+// Login:
+//
+//	client: NewOpaqueLogin             -> KE1
+//	server: OpaqueServer.StartLogin(record, KE1) -> KE2
+//	client: OpaqueLogin.Finish(KE2)    -> KE3, session key, export key
+//	server: OpaqueServerLogin.Finish(KE3) -> session key
+//
+// The messages are encoded as in RFC 9807. The Options of the client
+// name itself as LocalIdentity and the server as RemoteIdentity, and
+// the other way around for the server; empty identities default to the
+// public keys. Options.Argon2 selects Argon2id as the key stretching
+// function of the client, with a zero salt of 16 bytes unless a salt is
+// given, and must be the same at registration and login. Options.Rand
+// is the source of the blinds, nonces and key shares. The other fields
+// are not used by OPAQUE and must be left unset.
+
+// Nn and Nseed of RFC 9807.
+const (
+	opaqueNonceSize = 32
+	opaqueSeedSize  = 32
+)
+
+// OpaqueServer is the long-term state of an OPAQUE server, shared by
+// all its clients: the seed of the per-client OPRF keys and the AKE
+// key pair. It must be kept secret.
+type OpaqueServer struct {
+	Curve      string
+	OPRFSeed   []byte
+	PrivateKey []byte
+	PublicKey  []byte
+}
+
+// OpaqueRecord is what the server stores for a client, under the
+// credential identifier used at registration: the public key of the
+// client, the masking key and the envelope.
+type OpaqueRecord struct {
+	Curve           string
+	ClientPublicKey []byte
+	MaskingKey      []byte
+	Envelope        []byte
+}
+
+// OpaqueRegistration is the state of the client during registration.
+type OpaqueRegistration struct {
+	g     *oprfGroup
+	opts  Options
+	pw    []byte
+	blind *big.Int
+}
+
+// OpaqueLogin is the state of the client during login.
+type OpaqueLogin struct {
+	g            *oprfGroup
+	opts         Options
+	pw           []byte
+	blind        *big.Int
+	ke1          []byte
+	clientSecret *big.Int
+	K, exportKey []byte
+}
+
+// OpaqueServerLogin is the state of the server during login.
+type OpaqueServerLogin struct {
+	expectedMAC []byte
+	sessionKey  []byte
+	K           []byte
+}
+
+// NewOpaqueServer generates the long-term state of a server on the
+// given curve.
+func NewOpaqueServer(curve string) (*OpaqueServer, error) {
+	g, err := newOPRFGroup(curve)
+	if err != nil {
+		return nil, err
+	}
+	s := &OpaqueServer{Curve: curve, OPRFSeed: make([]byte, g.hash().Size())}
+	if _, err = io.ReadFull(rand.Reader, s.OPRFSeed); err != nil {
+		return nil, err
+	}
+	sk, pk, err := g.generateAuthKeyPair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	s.PrivateKey, s.PublicKey = g.serializeScalar(sk), g.serializeElement(pk)
+	return s, nil
+}
+
+// NewOpaqueRegistration starts the registration of the client with the
+// password pw and returns the request for the server.
+func NewOpaqueRegistration(pw []byte, curve string, opts Options) (r *OpaqueRegistration, request []byte, err error) {
+	if err = checkOpaqueOptions(opts); err != nil {
+		return
+	}
+	g, err := newOPRFGroup(curve)
+	if err != nil {
+		return
+	}
+	blind, request, err := g.blind(opts.reader(), pw)
+	if err != nil {
+		return
+	}
+	return &OpaqueRegistration{g: g, opts: opts, pw: pw, blind: blind}, request, nil
+}
+
+// RegistrationResponse answers the registration request of the client
+// with the given credential identifier, e.g. its user name, which must
+// be the same at login.
+func (s *OpaqueServer) RegistrationResponse(request, credentialID []byte) ([]byte, error) {
+	g, _, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+	oprfKey, err := g.oprfKey(s.OPRFSeed, credentialID)
+	if err != nil {
+		return nil, err
+	}
+	evaluated, err := g.blindEvaluate(oprfKey, request)
+	if err != nil {
+		return nil, err
+	}
+	return append(evaluated, s.PublicKey...), nil
+}
+
+// Finish completes the registration with the response of the server.
+// It returns the upload for the server, which turns it into the record
+// with NewRecord, and the export key, a secret that only the client
+// can derive again at login.
+func (r *OpaqueRegistration) Finish(response []byte) (upload, exportKey []byte, err error) {
+	if r == nil {
+		return nil, nil, ErrNotInitialized
+	}
+	g := r.g
+	noe := g.elementSize()
+	if len(response) != 2*noe {
+		return nil, nil, fmt.Errorf("%w: registration response of %d bytes", ErrMalformedMessage, len(response))
+	}
+	evaluated, serverPK := response[:noe], response[noe:]
+	if _, err = g.deserializeElement(serverPK); err != nil {
+		return
+	}
+	rwd, err := g.randomizedPassword(r.pw, r.blind, evaluated, r.opts.Argon2)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, opaqueNonceSize)
+	if _, err = io.ReadFull(r.opts.reader(), nonce); err != nil {
+		return
+	}
+	envelope, clientPK, maskingKey, exportKey, err := g.storeEnvelope(rwd, nonce, serverPK, []byte(r.opts.RemoteIdentity), []byte(r.opts.LocalIdentity))
+	if err != nil {
+		return
+	}
+	upload = append(append(clientPK, maskingKey...), envelope...)
+	return upload, exportKey, nil
+}
+
+// NewRecord checks the upload of the client at the end of the
+// registration and returns the record to store.
+func (s *OpaqueServer) NewRecord(upload []byte) (*OpaqueRecord, error) {
+	g, _, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+	rec, err := g.parseRecord(upload)
+	if err != nil {
+		return nil, err
+	}
+	rec.Curve = s.Curve
+	return rec, nil
+}
+
+// NewOpaqueLogin starts the login of the client with the password pw
+// and returns the message KE1 for the server.
+func NewOpaqueLogin(pw []byte, curve string, opts Options) (l *OpaqueLogin, ke1 []byte, err error) {
+	if err = checkOpaqueOptions(opts); err != nil {
+		return
+	}
+	g, err := newOPRFGroup(curve)
+	if err != nil {
+		return
+	}
+	r := opts.reader()
+	blind, request, err := g.blind(r, pw)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, opaqueNonceSize)
+	if _, err = io.ReadFull(r, nonce); err != nil {
+		return
+	}
+	secret, keyshare, err := g.generateAuthKeyPair(r)
+	if err != nil {
+		return
+	}
+	// KE1 = blinded_message || client_nonce || client_public_keyshare
+	ke1 = append(append(request, nonce...), g.serializeElement(keyshare)...)
+	l = &OpaqueLogin{g: g, opts: opts, pw: pw, blind: blind, ke1: ke1, clientSecret: secret}
+	return l, ke1, nil
+}
+
+// StartLogin answers the message KE1 of the client whose record is
+// stored under the given credential identifier, and returns the
+// message KE2 for the client. For a client that is not registered,
+// record must be nil: the server then answers with a fake record, so
+// that the client cannot tell whether it is registered, and login
+// fails as for a wrong password.
+func (s *OpaqueServer) StartLogin(record *OpaqueRecord, credentialID, ke1 []byte, opts Options) (l *OpaqueServerLogin, ke2 []byte, err error) {
+	if err = checkOpaqueOptions(opts); err != nil {
+		return
+	}
+	g, sk, err := s.keys()
+	if err != nil {
+		return
+	}
+	r := opts.reader()
+	if record == nil {
+		if record, err = g.fakeRecord(r); err != nil {
+			return
+		}
+	} else if record.Curve != s.Curve {
+		return nil, nil, fmt.Errorf("%w: record for %q", ErrCurveMismatch, record.Curve)
+	}
+	clientPK, err := g.deserializeElement(record.ClientPublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidVerifier, err)
+	}
+	nh := g.hash().Size()
+	if len(record.MaskingKey) != nh || len(record.Envelope) != opaqueNonceSize+nh {
+		return nil, nil, fmt.Errorf("%w: record", ErrInvalidVerifier)
+	}
+
+	noe := g.elementSize()
+	if len(ke1) != 2*noe+opaqueNonceSize {
+		return nil, nil, fmt.Errorf("%w: KE1 of %d bytes", ErrMalformedMessage, len(ke1))
+	}
+	clientKeyshare, err := g.deserializeElement(ke1[noe+opaqueNonceSize:])
+	if err != nil {
+		return
+	}
+
+	// CredentialResponse = evaluated_message || masking_nonce || masked_response
+	oprfKey, err := g.oprfKey(s.OPRFSeed, credentialID)
+	if err != nil {
+		return
+	}
+	response, err := g.blindEvaluate(oprfKey, ke1[:noe])
+	if err != nil {
+		return
+	}
+	maskingNonce := make([]byte, opaqueNonceSize)
+	if _, err = io.ReadFull(r, maskingNonce); err != nil {
+		return
+	}
+	pad, err := g.credentialResponsePad(record.MaskingKey, maskingNonce)
+	if err != nil {
+		return
+	}
+	response = append(response, maskingNonce...)
+	response = append(response, xorBytes(pad, append(append([]byte(nil), s.PublicKey...), record.Envelope...))...)
+
+	// AuthResponse = server_nonce || server_public_keyshare || server_mac
+	serverNonce := make([]byte, opaqueNonceSize)
+	if _, err = io.ReadFull(r, serverNonce); err != nil {
+		return
+	}
+	secret, keyshare, err := g.generateAuthKeyPair(r)
+	if err != nil {
+		return
+	}
+	serverKeyshare := g.serializeElement(keyshare)
+	serverID, clientID := identityOr(opts.LocalIdentity, s.PublicKey), identityOr(opts.RemoteIdentity, record.ClientPublicKey)
+	preamble := opaquePreamble(opts.Context, clientID, ke1, serverID, response, serverNonce, serverKeyshare)
+	ikm := append(g.serializeElement(g.scalarMult(clientKeyshare, secret)),
+		g.serializeElement(g.scalarMult(clientKeyshare, sk))...)
+	ikm = append(ikm, g.serializeElement(g.scalarMult(clientPK, secret))...)
+	km2, km3, sessionKey, err := g.deriveOpaqueKeys(ikm, preamble)
+	if err != nil {
+		return
+	}
+	serverMAC := hmacSum(g.hash, km2, g.sum(preamble))
+	l = &OpaqueServerLogin{
+		expectedMAC: hmacSum(g.hash, km3, g.sum(preamble, serverMAC)),
+		sessionKey:  sessionKey,
+	}
+	ke2 = append(response, serverNonce...)
+	ke2 = append(ke2, serverKeyshare...)
+	ke2 = append(ke2, serverMAC...)
+	return l, ke2, nil
+}
+
+// Finish completes the login with the message KE2 of the server and
+// returns the message KE3 for the server. It returns
+// ErrEnvelopeRecovery if the password is wrong or the client is not
+// registered, and ErrConfirmationFailed if the server could not be
+// authenticated.
+func (l *OpaqueLogin) Finish(ke2 []byte) (ke3 []byte, err error) {
+	if l == nil {
+		return nil, ErrNotInitialized
+	}
+	g := l.g
+	noe, nh := g.elementSize(), g.hash().Size()
+	maskedSize := noe + opaqueNonceSize + nh
+	if len(ke2) != noe+opaqueNonceSize+maskedSize+opaqueNonceSize+noe+nh {
+		return nil, fmt.Errorf("%w: KE2 of %d bytes", ErrMalformedMessage, len(ke2))
+	}
+	response, rest := ke2[:noe+opaqueNonceSize+maskedSize], ke2[noe+opaqueNonceSize+maskedSize:]
+	serverNonce, serverKeyshare, serverMAC := rest[:opaqueNonceSize], rest[opaqueNonceSize:opaqueNonceSize+noe], rest[opaqueNonceSize+noe:]
+
+	// RecoverCredentials
+	rwd, err := g.randomizedPassword(l.pw, l.blind, response[:noe], l.opts.Argon2)
+	if err != nil {
+		return
+	}
+	maskingKey, err := hkdfExpand(g.hash, rwd, []byte("MaskingKey"), nh)
+	if err != nil {
+		return
+	}
+	pad, err := g.credentialResponsePad(maskingKey, response[noe:noe+opaqueNonceSize])
+	if err != nil {
+		return
+	}
+	unmasked := xorBytes(pad, response[noe+opaqueNonceSize:])
+	serverPKBytes, envelope := unmasked[:noe], unmasked[noe:]
+	serverPK, err := g.deserializeElement(serverPKBytes)
+	if err != nil {
+		// a wrong masking key unmasks garbage
+		return nil, ErrEnvelopeRecovery
+	}
+	serverID := []byte(l.opts.RemoteIdentity)
+	clientSK, clientPK, exportKey, err := g.recoverEnvelope(rwd, serverPKBytes, envelope, serverID, []byte(l.opts.LocalIdentity))
+	if err != nil {
+		return
+	}
+
+	// AuthClientFinalize
+	keyshare, err := g.deserializeElement(serverKeyshare)
+	if err != nil {
+		return
+	}
+	clientID := identityOr(l.opts.LocalIdentity, g.serializeElement(clientPK))
+	preamble := opaquePreamble(l.opts.Context, clientID, l.ke1, identityOr(l.opts.RemoteIdentity, serverPKBytes), response, serverNonce, serverKeyshare)
+	ikm := append(g.serializeElement(g.scalarMult(keyshare, l.clientSecret)),
+		g.serializeElement(g.scalarMult(serverPK, l.clientSecret))...)
+	ikm = append(ikm, g.serializeElement(g.scalarMult(keyshare, clientSK))...)
+	km2, km3, sessionKey, err := g.deriveOpaqueKeys(ikm, preamble)
+	if err != nil {
+		return
+	}
+	if !hmac.Equal(serverMAC, hmacSum(g.hash, km2, g.sum(preamble))) {
+		return nil, ErrConfirmationFailed
+	}
+	l.K, l.exportKey = sessionKey, exportKey
+	return hmacSum(g.hash, km3, g.sum(preamble, serverMAC)), nil
+}
+
+// SessionKey returns the session key once Finish succeeded.
+func (l *OpaqueLogin) SessionKey() ([]byte, error) {
+	if l == nil {
+		return nil, ErrNotInitialized
+	}
+	if l.K == nil {
+		return nil, ErrNoSessionKey
+	}
+	return l.K, nil
+}
+
+// ExportKey returns the export key once Finish succeeded. It is the
+// same as the one returned at registration, and unknown to the server.
+func (l *OpaqueLogin) ExportKey() ([]byte, error) {
+	if l == nil {
+		return nil, ErrNotInitialized
+	}
+	if l.exportKey == nil {
+		return nil, ErrNoSessionKey
+	}
+	return l.exportKey, nil
+}
+
+// Finish checks the message KE3 of the client. It returns
+// ErrConfirmationFailed if the client could not be authenticated, in
+// which case the login failed.
+func (l *OpaqueServerLogin) Finish(ke3 []byte) error {
+	if l == nil {
+		return ErrNotInitialized
+	}
+	if !hmac.Equal(ke3, l.expectedMAC) {
+		return ErrConfirmationFailed
+	}
+	l.K = l.sessionKey
+	return nil
+}
+
+// SessionKey returns the session key once Finish authenticated the
+// client.
+func (l *OpaqueServerLogin) SessionKey() ([]byte, error) {
+	if l == nil {
+		return nil, ErrNotInitialized
+	}
+	if l.K == nil {
+		return nil, ErrNoSessionKey
+	}
+	return l.K, nil
+}
+
+// MarshalBinary encodes s as a header byte with the curve ID of the
+// binary encoding followed by the OPRF seed, the private key and the
+// public key.
+func (s *OpaqueServer) MarshalBinary() ([]byte, error) {
+	if _, _, err := s.keys(); err != nil {
+		return nil, err
+	}
+	b := []byte{binaryCurveIDs[s.Curve]}
+	b = append(b, s.OPRFSeed...)
+	b = append(b, s.PrivateKey...)
+	return append(b, s.PublicKey...), nil
+}
+
+// UnmarshalBinary decodes a server encoded with MarshalBinary.
+func (s *OpaqueServer) UnmarshalBinary(data []byte) error {
+	if s == nil {
+		return ErrNotInitialized
+	}
+	g, err := opaqueGroupFromHeader(data)
+	if err != nil {
+		return err
+	}
+	nh, nsk := g.hash().Size(), g.scalarSize()
+	if len(data) != 1+nh+nsk+g.elementSize() {
+		return fmt.Errorf("%w: server of %d bytes", ErrInvalidVerifier, len(data))
+	}
+	data = data[1:]
+	t := OpaqueServer{
+		Curve:      g.name,
+		OPRFSeed:   append([]byte(nil), data[:nh]...),
+		PrivateKey: append([]byte(nil), data[nh:nh+nsk]...),
+		PublicKey:  append([]byte(nil), data[nh+nsk:]...),
+	}
+	if _, _, err = t.keys(); err != nil {
+		return err
+	}
+	*s = t
+	return nil
+}
+
+// MarshalBinary encodes r as a header byte with the curve ID of the
+// binary encoding followed by the RegistrationRecord of RFC 9807.
+func (r *OpaqueRecord) MarshalBinary() ([]byte, error) {
+	if r == nil {
+		return nil, ErrNotInitialized
+	}
+	g, err := newOPRFGroup(r.Curve)
+	if err != nil {
+		return nil, err
+	}
+	b := append([]byte{binaryCurveIDs[r.Curve]}, r.ClientPublicKey...)
+	b = append(b, r.MaskingKey...)
+	b = append(b, r.Envelope...)
+	if _, err = g.parseRecord(b[1:]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a record encoded with MarshalBinary.
+func (r *OpaqueRecord) UnmarshalBinary(data []byte) error {
+	if r == nil {
+		return ErrNotInitialized
+	}
+	g, err := opaqueGroupFromHeader(data)
+	if err != nil {
+		return err
+	}
+	rec, err := g.parseRecord(data[1:])
+	if err != nil {
+		return err
+	}
+	rec.Curve = g.name
+	*r = *rec
+	return nil
+}
+
+// checkOpaqueOptions rejects the fields of opts that only apply to the
+// SPAKE2 and CPace modes.
+func checkOpaqueOptions(opts Options) error {
+	switch {
+	case opts.Mode != ModeLegacy:
+		return fmt.Errorf("%w: Mode", ErrUnsupportedOption)
+	case opts.HashPassword:
+		return fmt.Errorf("%w: HashPassword", ErrUnsupportedOption)
+	case opts.SessionID != nil:
+		return fmt.Errorf("%w: SessionID", ErrUnsupportedOption)
+	case opts.LocalAssociatedData != nil || opts.RemoteAssociatedData != nil:
+		return fmt.Errorf("%w: associated data", ErrUnsupportedOption)
+	case opts.TranscriptVersion != 0:
+		return fmt.Errorf("%w: TranscriptVersion", ErrUnsupportedOption)
+	}
+	return nil
+}
+
+// opaqueGroupFromHeader returns the group of the curve ID in the first
+// byte of data.
+func opaqueGroupFromHeader(data []byte) (*oprfGroup, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidVerifier)
+	}
+	curve, ok := binaryCurveName(data[0])
+	if !ok {
+		return nil, fmt.Errorf("%w: binary curve ID %d", ErrUnknownCurve, data[0])
+	}
+	return newOPRFGroup(curve)
+}
+
+// keys returns the group of s and its private key, after checking
+// that the public key belongs to it.
+func (s *OpaqueServer) keys() (*oprfGroup, *big.Int, error) {
+	if s == nil {
+		return nil, nil, ErrNotInitialized
+	}
+	g, err := newOPRFGroup(s.Curve)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(s.OPRFSeed) != g.hash().Size() {
+		return nil, nil, fmt.Errorf("%w: OPRF seed", ErrInvalidVerifier)
+	}
+	sk, err := g.deserializeScalar(s.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: private key", ErrInvalidVerifier)
+	}
+	if !hmac.Equal(g.serializeElement(g.scalarBaseMult(sk)), s.PublicKey) {
+		return nil, nil, fmt.Errorf("%w: public key", ErrInvalidVerifier)
+	}
+	return g, sk, nil
+}
+
+// parseRecord decodes a RegistrationRecord:
+// client_public_key || masking_key || envelope.
+func (g *oprfGroup) parseRecord(b []byte) (*OpaqueRecord, error) {
+	noe, nh := g.elementSize(), g.hash().Size()
+	if len(b) != noe+nh+opaqueNonceSize+nh {
+		return nil, fmt.Errorf("%w: record of %d bytes", ErrInvalidVerifier, len(b))
+	}
+	if _, err := g.deserializeElement(b[:noe]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidVerifier, err)
+	}
+	return &OpaqueRecord{
+		ClientPublicKey: append([]byte(nil), b[:noe]...),
+		MaskingKey:      append([]byte(nil), b[noe:noe+nh]...),
+		Envelope:        append([]byte(nil), b[noe+nh:]...),
+	}, nil
+}
+
+// fakeRecord returns a record with a random key pair and masking key
+// for a client that is not registered.
+func (g *oprfGroup) fakeRecord(r io.Reader) (*OpaqueRecord, error) {
+	_, pk, err := g.generateAuthKeyPair(r)
+	if err != nil {
+		return nil, err
+	}
+	nh := g.hash().Size()
+	rec := &OpaqueRecord{
+		Curve:           g.name,
+		ClientPublicKey: g.serializeElement(pk),
+		MaskingKey:      make([]byte, nh),
+		Envelope:        make([]byte, opaqueNonceSize+nh),
+	}
+	_, err = io.ReadFull(r, rec.MaskingKey)
+	return rec, err
+}
+
+// oprfKey derives the OPRF key of a client from the OPRF seed of the
+// server and the credential identifier of the client. The seed has the
+// size Nok of a scalar, unlike the seeds of the AKE key pairs.
+func (g *oprfGroup) oprfKey(oprfSeed, credentialID []byte) (*big.Int, error) {
+	seed, err := hkdfExpand(g.hash, oprfSeed, append(append([]byte(nil), credentialID...), "OprfKey"...), g.scalarSize())
+	if err != nil {
+		return nil, err
+	}
+	sk, _, err := g.deriveKeyPair(seed, []byte("OPAQUE-DeriveKeyPair"))
+	return sk, err
+}
+
+// generateAuthKeyPair derives a random key pair for the AKE.
+func (g *oprfGroup) generateAuthKeyPair(r io.Reader) (*big.Int, Point, error) {
+	seed := make([]byte, opaqueSeedSize)
+	if _, err := io.ReadFull(r, seed); err != nil {
+		return nil, Point{}, err
+	}
+	return g.deriveDiffieHellmanKeyPair(seed)
+}
+
+func (g *oprfGroup) deriveDiffieHellmanKeyPair(seed []byte) (*big.Int, Point, error) {
+	return g.deriveKeyPair(seed, []byte("OPAQUE-DeriveDiffieHellmanKeyPair"))
+}
+
+// randomizedPassword computes the OPRF output of the password and
+// returns Extract("", oprf_output || Stretch(oprf_output)).
+func (g *oprfGroup) randomizedPassword(pw []byte, blind *big.Int, evaluated []byte, a *Argon2Params) ([]byte, error) {
+	output, err := g.finalize(pw, blind, evaluated)
+	if err != nil {
+		return nil, err
+	}
+	stretched := output
+	if a != nil {
+		salt := a.Salt
+		if len(salt) == 0 {
+			salt = make([]byte, 16)
+		}
+		stretched = a.key(output, salt, uint32(g.hash().Size()))
+	}
+	return hkdfExtract(g.hash, nil, append(append([]byte(nil), output...), stretched...)), nil
+}
+
+// envelopeKeys derives the authentication key, the export key and the
+// seed of the key pair of the client from the randomized password and
+// the envelope nonce.
+func (g *oprfGroup) envelopeKeys(rwd, nonce []byte) (authKey, exportKey, seed []byte, err error) {
+	nh := g.hash().Size()
+	info := func(label string) []byte { return append(append([]byte(nil), nonce...), label...) }
+	if authKey, err = hkdfExpand(g.hash, rwd, info("AuthKey"), nh); err != nil {
+		return
+	}
+	if exportKey, err = hkdfExpand(g.hash, rwd, info("ExportKey"), nh); err != nil {
+		return
+	}
+	seed, err = hkdfExpand(g.hash, rwd, info("PrivateKey"), opaqueSeedSize)
+	return
+}
+
+// storeEnvelope is Store of RFC 9807: it returns the envelope
+// envelope_nonce || auth_tag, the public key of the client, the
+// masking key and the export key.
+func (g *oprfGroup) storeEnvelope(rwd, nonce, serverPK, serverID, clientID []byte) (envelope, clientPK, maskingKey, exportKey []byte, err error) {
+	if maskingKey, err = hkdfExpand(g.hash, rwd, []byte("MaskingKey"), g.hash().Size()); err != nil {
+		return
+	}
+	authKey, exportKey, seed, err := g.envelopeKeys(rwd, nonce)
+	if err != nil {
+		return
+	}
+	_, pk, err := g.deriveDiffieHellmanKeyPair(seed)
+	if err != nil {
+		return
+	}
+	clientPK = g.serializeElement(pk)
+	creds := cleartextCredentials(serverPK, clientPK, serverID, clientID)
+	tag := hmacSum(g.hash, authKey, append(append([]byte(nil), nonce...), creds...))
+	envelope = append(append([]byte(nil), nonce...), tag...)
+	return
+}
+
+// recoverEnvelope is Recover of RFC 9807: it opens the envelope and
+// returns the key pair and the export key of the client.
+func (g *oprfGroup) recoverEnvelope(rwd, serverPK, envelope, serverID, clientID []byte) (clientSK *big.Int, clientPK Point, exportKey []byte, err error) {
+	nonce, tag := envelope[:opaqueNonceSize], envelope[opaqueNonceSize:]
+	authKey, exportKey, seed, err := g.envelopeKeys(rwd, nonce)
+	if err != nil {
+		return
+	}
+	clientSK, clientPK, err = g.deriveDiffieHellmanKeyPair(seed)
+	if err != nil {
+		return
+	}
+	creds := cleartextCredentials(serverPK, g.serializeElement(clientPK), serverID, clientID)
+	if !hmac.Equal(tag, hmacSum(g.hash, authKey, append(append([]byte(nil), nonce...), creds...))) {
+		return nil, Point{}, nil, ErrEnvelopeRecovery
+	}
+	return
+}
+
+// credentialResponsePad is the pad that masks the public key of the
+// server and the envelope in the credential response.
+func (g *oprfGroup) credentialResponsePad(maskingKey, nonce []byte) ([]byte, error) {
+	size := g.elementSize() + opaqueNonceSize + g.hash().Size()
+	return hkdfExpand(g.hash, maskingKey, append(append([]byte(nil), nonce...), "CredentialResponsePad"...), size)
+}
+
+// deriveOpaqueKeys is DeriveKeys of the 3DH key exchange: the MAC keys
+// Km2 and Km3 and the session key.
+func (g *oprfGroup) deriveOpaqueKeys(ikm, preamble []byte) (km2, km3, sessionKey []byte, err error) {
+	prk := hkdfExtract(g.hash, nil, ikm)
+	transcriptHash := g.sum(preamble)
+	handshakeSecret, err := g.deriveSecret(prk, "HandshakeSecret", transcriptHash)
+	if err != nil {
+		return
+	}
+	if sessionKey, err = g.deriveSecret(prk, "SessionKey", transcriptHash); err != nil {
+		return
+	}
+	if km2, err = g.deriveSecret(handshakeSecret, "ServerMAC", nil); err != nil {
+		return
+	}
+	km3, err = g.deriveSecret(handshakeSecret, "ClientMAC", nil)
+	return
+}
+
+// deriveSecret is Derive-Secret, i.e. Expand-Label with the length of
+// the hash: Expand(secret, I2OSP(Nx, 2) || len || "OPAQUE-" || label
+// || len || context, Nx) with 1-byte lengths.
+func (g *oprfGroup) deriveSecret(secret []byte, label string, context []byte) ([]byte, error) {
+	nx := g.hash().Size()
+	info := []byte{byte(nx >> 8), byte(nx), byte(len("OPAQUE-" + label))}
+	info = append(info, "OPAQUE-"+label...)
+	info = append(info, byte(len(context)))
+	info = append(info, context...)
+	return hkdfExpand(g.hash, secret, info, nx)
+}
+
+// sum returns the hash of the concatenation of parts.
+func (g *oprfGroup) sum(parts ...[]byte) []byte {
+	H := g.hash()
+	for _, part := range parts {
+		H.Write(part)
+	}
+	return H.Sum(nil)
+}
+
+// opaquePreamble is the preamble of the 3DH transcript.
+func opaquePreamble(context, clientID, ke1, serverID, credentialResponse, serverNonce, serverKeyshare []byte) []byte {
+	b := append([]byte("OPAQUEv1-"), i2osp2(context)...)
+	b = append(b, i2osp2(clientID)...)
+	b = append(b, ke1...)
+	b = append(b, i2osp2(serverID)...)
+	b = append(b, credentialResponse...)
+	b = append(b, serverNonce...)
+	return append(b, serverKeyshare...)
+}
+
+// cleartextCredentials encodes the CleartextCredentials
+// server_public_key || server_identity || client_identity, where the
+// identities default to the public keys.
+func cleartextCredentials(serverPK, clientPK, serverID, clientID []byte) []byte {
+	b := append([]byte(nil), serverPK...)
+	b = append(b, i2osp2(identityOr(string(serverID), serverPK))...)
+	return append(b, i2osp2(identityOr(string(clientID), clientPK))...)
+}
+
+// identityOr returns id, or the public key pk if id is empty.
+func identityOr(id string, pk []byte) []byte {
+	if id == "" {
+		return pk
+	}
+	return []byte(id)
+}
+
+func xorBytes(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
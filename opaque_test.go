@@ -0,0 +1,370 @@
+package pake
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+var opaqueCurves = []string{"ristretto255"}
+
+// opaqueRegister registers pw for the credential ID "alice" and
+// returns the record and the export key.
+func opaqueRegister(t *testing.T, s *OpaqueServer, pw []byte, opts Options) (*OpaqueRecord, []byte) {
+	t.Helper()
+	r, request, err := NewOpaqueRegistration(pw, s.Curve, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := s.RegistrationResponse(request, []byte("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, exportKey, err := r.Finish(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := s.NewRecord(upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record, exportKey
+}
+
+// opaqueLogin runs the login up to KE2 and returns the states of both
+// parties and KE2.
+func opaqueLogin(t *testing.T, s *OpaqueServer, record *OpaqueRecord, pw []byte, clientOpts, serverOpts Options) (*OpaqueLogin, *OpaqueServerLogin, []byte) {
+	t.Helper()
+	client, ke1, err := NewOpaqueLogin(pw, s.Curve, clientOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, ke2, err := s.StartLogin(record, []byte("alice"), ke1, serverOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server, ke2
+}
+
+func TestOpaque(t *testing.T) {
+	for _, curve := range opaqueCurves {
+		s, err := NewOpaqueServer(curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientOpts := Options{LocalIdentity: "alice", RemoteIdentity: "example.com", Context: []byte("app")}
+		serverOpts := Options{LocalIdentity: "example.com", RemoteIdentity: "alice", Context: []byte("app")}
+		record, exportKey := opaqueRegister(t, s, []byte("password"), clientOpts)
+
+		client, server, ke2 := opaqueLogin(t, s, record, []byte("password"), clientOpts, serverOpts)
+		if _, err = server.SessionKey(); err != ErrNoSessionKey {
+			t.Errorf("%s: the server has no session key before KE3, got %v", curve, err)
+		}
+		ke3, err := client.Finish(ke2)
+		if err != nil {
+			t.Fatalf("%s: %v", curve, err)
+		}
+		if err = server.Finish(ke3); err != nil {
+			t.Fatalf("%s: %v", curve, err)
+		}
+		kC, _ := client.SessionKey()
+		kS, _ := server.SessionKey()
+		if !bytes.Equal(kC, kS) || len(kC) == 0 {
+			t.Errorf("%s: session keys not equal", curve)
+		}
+		if eC, _ := client.ExportKey(); !bytes.Equal(eC, exportKey) {
+			t.Errorf("%s: export key differs from registration", curve)
+		}
+	}
+}
+
+func TestOpaqueDefaultIdentities(t *testing.T) {
+	s, err := NewOpaqueServer("ristretto255")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _ := opaqueRegister(t, s, []byte("password"), Options{})
+	client, server, ke2 := opaqueLogin(t, s, record, []byte("password"), Options{}, Options{})
+	ke3, err := client.Finish(ke2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.Finish(ke3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpaqueArgon2(t *testing.T) {
+	s, err := NewOpaqueServer("ristretto255")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _ := opaqueRegister(t, s, []byte("password"), Options{Argon2: cheapArgon2})
+	client, _, ke2 := opaqueLogin(t, s, record, []byte("password"), Options{Argon2: cheapArgon2}, Options{})
+	if _, err = client.Finish(ke2); err != nil {
+		t.Errorf("login with the same Argon2 parameters: %v", err)
+	}
+	client, _, ke2 = opaqueLogin(t, s, record, []byte("password"), Options{}, Options{})
+	if _, err = client.Finish(ke2); err != ErrEnvelopeRecovery {
+		t.Errorf("login without Argon2 should fail to open the envelope, got %v", err)
+	}
+}
+
+func TestOpaqueFailures(t *testing.T) {
+	flip := func(b []byte, i int) []byte {
+		b = append([]byte(nil), b...)
+		b[i] ^= 1
+		return b
+	}
+	for _, curve := range opaqueCurves {
+		s, err := NewOpaqueServer(curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record, _ := opaqueRegister(t, s, []byte("password"), Options{})
+
+		// wrong password
+		client, _, ke2 := opaqueLogin(t, s, record, []byte("passw0rd"), Options{}, Options{})
+		if _, err = client.Finish(ke2); err != ErrEnvelopeRecovery {
+			t.Errorf("%s: wrong password should fail to open the envelope, got %v", curve, err)
+		}
+
+		// unknown client, answered with a fake record
+		client, _, ke2 = opaqueLogin(t, s, nil, []byte("password"), Options{}, Options{})
+		if _, err = client.Finish(ke2); err != ErrEnvelopeRecovery {
+			t.Errorf("%s: unknown client should fail to open the envelope, got %v", curve, err)
+		}
+
+		// server MAC
+		client, _, ke2 = opaqueLogin(t, s, record, []byte("password"), Options{}, Options{})
+		if _, err = client.Finish(flip(ke2, len(ke2)-1)); err != ErrConfirmationFailed {
+			t.Errorf("%s: tampered KE2 should fail, got %v", curve, err)
+		}
+		if _, err = client.Finish(ke2[1:]); !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("%s: short KE2 should be malformed, got %v", curve, err)
+		}
+
+		// client MAC
+		client, server, ke2 := opaqueLogin(t, s, record, []byte("password"), Options{}, Options{})
+		ke3, err := client.Finish(ke2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = server.Finish(flip(ke3, 0)); err != ErrConfirmationFailed {
+			t.Errorf("%s: tampered KE3 should fail, got %v", curve, err)
+		}
+		if _, err = server.SessionKey(); err != ErrNoSessionKey {
+			t.Errorf("%s: no session key after a failed KE3, got %v", curve, err)
+		}
+
+		// context and identities are bound into the transcript
+		for name, opts := range map[string][2]Options{
+			"context":  {{Context: []byte("a")}, {Context: []byte("b")}},
+			"identity": {{LocalIdentity: "alice"}, {RemoteIdentity: "bob"}},
+		} {
+			client, _, ke2 = opaqueLogin(t, s, record, []byte("password"), opts[0], opts[1])
+			if _, err = client.Finish(ke2); err == nil {
+				t.Errorf("%s: %s mismatch should fail", curve, name)
+			}
+		}
+	}
+}
+
+func TestOpaqueMarshal(t *testing.T) {
+	for _, curve := range opaqueCurves {
+		s, err := NewOpaqueServer(curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s2 OpaqueServer
+		if err = s2.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%s: %v", curve, err)
+		}
+		if s2.Curve != curve || !bytes.Equal(s2.PrivateKey, s.PrivateKey) || !bytes.Equal(s2.OPRFSeed, s.OPRFSeed) {
+			t.Errorf("%s: server does not round trip", curve)
+		}
+		if err = s2.UnmarshalBinary(b[:len(b)-1]); !errors.Is(err, ErrInvalidVerifier) {
+			t.Errorf("%s: short server should be invalid, got %v", curve, err)
+		}
+		// the public key must match the private key
+		b[len(b)-1] ^= 1
+		if err = s2.UnmarshalBinary(b); err == nil {
+			t.Errorf("%s: server with a wrong public key should be rejected", curve)
+		}
+
+		record, _ := opaqueRegister(t, s, []byte("password"), Options{})
+		b, err = record.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var record2 OpaqueRecord
+		if err = record2.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%s: %v", curve, err)
+		}
+		client, server, ke2 := opaqueLogin(t, decodedServer(t, s), &record2, []byte("password"), Options{}, Options{})
+		ke3, err := client.Finish(ke2)
+		if err != nil {
+			t.Fatalf("%s: login with a decoded record: %v", curve, err)
+		}
+		if err = server.Finish(ke3); err != nil {
+			t.Fatal(err)
+		}
+		if err = record2.UnmarshalBinary(b[:len(b)-1]); !errors.Is(err, ErrInvalidVerifier) {
+			t.Errorf("%s: short record should be invalid, got %v", curve, err)
+		}
+	}
+}
+
+// decodedServer returns a copy of s decoded from its binary encoding.
+func decodedServer(t *testing.T, s *OpaqueServer) *OpaqueServer {
+	t.Helper()
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := new(OpaqueServer)
+	if err = s2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	return s2
+}
+
+func TestOpaqueVectors(t *testing.T) {
+	// RFC 9807, appendix C: the real test vectors of OPAQUE-3DH with
+	// ristretto255-SHA512, without identities
+	var vectors []struct {
+		Curve, Context, CredentialIdentifier, Password                string
+		OPRFSeed, ServerPrivateKey, ServerPublicKey                   string
+		EnvelopeNonce, MaskingNonce, ServerNonce, ClientNonce         string
+		ClientKeyshareSeed, ServerKeyshareSeed                        string
+		BlindRegistration, BlindLogin                                 string
+		RegistrationRequest, RegistrationResponse, RegistrationUpload string
+		KE1, KE2, KE3, ExportKey, SessionKey                          string
+	}
+	readJSON(t, filepath.Join("testdata", "opaque", "opaque-3dh.json"), &vectors)
+	for _, v := range vectors {
+		g, err := newOPRFGroup(v.Curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the blinds are read as scalars through randomScalar, the
+		// nonces and key share seeds as they are
+		reader := func(blind string, rest ...string) Options {
+			k, err := g.deserializeScalar(mustHex(t, blind))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := append(make([]byte, 16), k.FillBytes(make([]byte, g.scalarSize()))...)
+			for _, r := range rest {
+				b = append(b, mustHex(t, r)...)
+			}
+			return Options{Context: mustHex(t, v.Context), Rand: bytes.NewReader(b)}
+		}
+		s := &OpaqueServer{
+			Curve:      v.Curve,
+			OPRFSeed:   mustHex(t, v.OPRFSeed),
+			PrivateKey: mustHex(t, v.ServerPrivateKey),
+			PublicKey:  mustHex(t, v.ServerPublicKey),
+		}
+		pw, credentialID := mustHex(t, v.Password), mustHex(t, v.CredentialIdentifier)
+		check := func(name string, got []byte, want string) {
+			if hex.EncodeToString(got) != want {
+				t.Errorf("%s: %s is %x, want %s", v.Curve, name, got, want)
+			}
+		}
+
+		r, request, err := NewOpaqueRegistration(pw, v.Curve, reader(v.BlindRegistration, v.EnvelopeNonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("registration request", request, v.RegistrationRequest)
+		response, err := s.RegistrationResponse(request, credentialID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("registration response", response, v.RegistrationResponse)
+		upload, exportKey, err := r.Finish(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("registration upload", upload, v.RegistrationUpload)
+		check("export key", exportKey, v.ExportKey)
+		record, err := s.NewRecord(upload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client, ke1, err := NewOpaqueLogin(pw, v.Curve, reader(v.BlindLogin, v.ClientNonce, v.ClientKeyshareSeed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("KE1", ke1, v.KE1)
+		serverOpts := Options{Context: mustHex(t, v.Context), Rand: bytes.NewReader(
+			append(append(mustHex(t, v.MaskingNonce), mustHex(t, v.ServerNonce)...), mustHex(t, v.ServerKeyshareSeed)...))}
+		server, ke2, err := s.StartLogin(record, credentialID, ke1, serverOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("KE2", ke2, v.KE2)
+		ke3, err := client.Finish(ke2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("KE3", ke3, v.KE3)
+		if err = server.Finish(ke3); err != nil {
+			t.Fatal(err)
+		}
+		kC, _ := client.SessionKey()
+		kS, _ := server.SessionKey()
+		check("client session key", kC, v.SessionKey)
+		check("server session key", kS, v.SessionKey)
+		eC, _ := client.ExportKey()
+		check("login export key", eC, v.ExportKey)
+	}
+}
+
+func TestOpaqueUnsupportedOptions(t *testing.T) {
+	s, err := NewOpaqueServer("ristretto255")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _ := opaqueRegister(t, s, []byte("password"), Options{})
+	for name, opts := range map[string]Options{
+		"Mode":              {Mode: ModeCPace},
+		"HashPassword":      {HashPassword: true},
+		"SessionID":         {SessionID: []byte("sid")},
+		"TranscriptVersion": {TranscriptVersion: 1},
+		"associated data":   {LocalAssociatedData: []byte("ad")},
+	} {
+		if _, _, err = NewOpaqueRegistration([]byte("password"), "ristretto255", opts); !errors.Is(err, ErrUnsupportedOption) {
+			t.Errorf("registration with %s: got %v", name, err)
+		}
+		if _, _, err = NewOpaqueLogin([]byte("password"), "ristretto255", opts); !errors.Is(err, ErrUnsupportedOption) {
+			t.Errorf("login with %s: got %v", name, err)
+		}
+		_, ke1, err := NewOpaqueLogin([]byte("password"), "ristretto255", Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = s.StartLogin(record, []byte("alice"), ke1, opts); !errors.Is(err, ErrUnsupportedOption) {
+			t.Errorf("server login with %s: got %v", name, err)
+		}
+	}
+}
+
+func TestOpaqueUnsupportedCurve(t *testing.T) {
+	for _, curve := range []string{"p256", "p384", "p521", "siec", "ed25519"} {
+		if _, err := NewOpaqueServer(curve); !errors.Is(err, ErrUnknownCurve) {
+			t.Errorf("%s should not be supported by OPAQUE, got %v", curve, err)
+		}
+		if _, _, err := NewOpaqueLogin([]byte("password"), curve, Options{}); !errors.Is(err, ErrUnknownCurve) {
+			t.Errorf("%s should not be supported by OPAQUE, got %v", curve, err)
+		}
+	}
+}
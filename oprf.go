@@ -0,0 +1,224 @@
+package pake
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+)
+
+// oprfGroup is the prime-order group and hash function of an OPRF
+// ciphersuite of RFC 9497, in the base mode (modeOPRF). Elements are
+// serialized compressed, scalars little-endian for ristretto255 and
+// big-endian for the NIST curves.
+type oprfGroup struct {
+	name     string // the curve name in this package
+	id       string // the identifier of the ciphersuite
+	curve    EllipticCurve
+	p, order *big.Int
+	hash     func() hash.Hash
+	l        int // the length L of HashToScalar
+}
+
+// oprfSuites are the identifiers, hash functions and HashToScalar
+// lengths of the ciphersuites of RFC 9497, section 4, by curve. The
+// OPRF input is the password, so only ristretto255, whose map to the
+// group is constant time, is supported: the NIST suites need the
+// simplified SWU map of hashtocurve.go, which is not.
+var oprfSuites = map[string]struct {
+	id   string
+	hash func() hash.Hash
+	l    int
+}{
+	"ristretto255": {"ristretto255-SHA512", sha512.New, 64},
+}
+
+// newOPRFGroup returns the OPRF group of a curve.
+func newOPRFGroup(curve string) (*oprfGroup, error) {
+	suite, ok := oprfSuites[curve]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q has no OPRF ciphersuite", ErrUnknownCurve, curve)
+	}
+	spec, _, err := lookupCurve(curve)
+	if err != nil {
+		return nil, err
+	}
+	return &oprfGroup{
+		name:  curve,
+		id:    suite.id,
+		curve: spec.Curve,
+		p:     spec.FieldP,
+		order: spec.Order,
+		hash:  suite.hash,
+		l:     suite.l,
+	}, nil
+}
+
+// contextString is "OPRFV1-" || I2OSP(mode, 1) || "-" || identifier,
+// for the base mode.
+func (g *oprfGroup) contextString() []byte {
+	return append([]byte("OPRFV1-\x00-"), g.id...)
+}
+
+func (g *oprfGroup) ristretto() bool {
+	_, ok := g.curve.(*Ristretto255Curve)
+	return ok
+}
+
+// elementSize is Noe, the length of a serialized element.
+func (g *oprfGroup) elementSize() int {
+	if g.ristretto() {
+		return 32
+	}
+	return 1 + (g.p.BitLen()+7)/8
+}
+
+// scalarSize is Nok, the length of a serialized scalar.
+func (g *oprfGroup) scalarSize() int {
+	return (g.order.BitLen() + 7) / 8
+}
+
+func (g *oprfGroup) serializeElement(pt Point) []byte {
+	return compressPoint(g.curve, g.p, pt.X, pt.Y)
+}
+
+// deserializeElement decodes an element of the other party and
+// rejects the identity.
+func (g *oprfGroup) deserializeElement(b []byte) (Point, error) {
+	if len(b) != g.elementSize() {
+		return Point{}, fmt.Errorf("%w: element of %d bytes", ErrInvalidPoint, len(b))
+	}
+	x, y, err := decompressPoint(g.curve, g.p, b)
+	if err != nil {
+		return Point{}, err
+	}
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return Point{}, ErrSmallOrderPoint
+	}
+	return Point{x, y}, nil
+}
+
+func (g *oprfGroup) serializeScalar(k *big.Int) []byte {
+	b := k.FillBytes(make([]byte, g.scalarSize()))
+	if g.ristretto() {
+		reverseBytes(b)
+	}
+	return b
+}
+
+// deserializeScalar decodes a non-zero scalar.
+func (g *oprfGroup) deserializeScalar(b []byte) (*big.Int, error) {
+	if len(b) != g.scalarSize() {
+		return nil, fmt.Errorf("%w: scalar of %d bytes", ErrMalformedMessage, len(b))
+	}
+	b = append([]byte(nil), b...)
+	if g.ristretto() {
+		reverseBytes(b)
+	}
+	k := new(big.Int).SetBytes(b)
+	if k.Sign() == 0 || k.Cmp(g.order) >= 0 {
+		return nil, fmt.Errorf("%w: scalar out of range", ErrMalformedMessage)
+	}
+	return k, nil
+}
+
+func (g *oprfGroup) scalarMult(pt Point, k *big.Int) Point {
+	x, y := scalarMult(g.curve, pt.X, pt.Y, k.Bytes())
+	return Point{x, y}
+}
+
+func (g *oprfGroup) scalarBaseMult(k *big.Int) Point {
+	x, y := scalarBaseMult(g.curve, k.Bytes())
+	return Point{x, y}
+}
+
+// hashToGroup is HashToGroup with the DST "HashToGroup-" ||
+// contextString.
+func (g *oprfGroup) hashToGroup(msg []byte) (Point, error) {
+	return HashToCurve(g.name, msg, append([]byte("HashToGroup-"), g.contextString()...))
+}
+
+// hashToScalar is HashToScalar: hash_to_field modulo the group order,
+// or the little-endian reduction of 64 uniform bytes for ristretto255.
+func (g *oprfGroup) hashToScalar(msg, dst []byte) (*big.Int, error) {
+	uniform, err := expandMessageXMD(g.hash, msg, dst, g.l)
+	if err != nil {
+		return nil, err
+	}
+	if g.ristretto() {
+		reverseBytes(uniform)
+	}
+	k := new(big.Int).SetBytes(uniform)
+	return k.Mod(k, g.order), nil
+}
+
+// deriveKeyPair is DeriveKeyPair of RFC 9497, section 3.2.1.
+func (g *oprfGroup) deriveKeyPair(seed, info []byte) (*big.Int, Point, error) {
+	deriveInput := append(append([]byte(nil), seed...), i2osp2(info)...)
+	dst := append([]byte("DeriveKeyPair"), g.contextString()...)
+	for counter := 0; counter < 256; counter++ {
+		sk, err := g.hashToScalar(append(deriveInput, byte(counter)), dst)
+		if err != nil {
+			return nil, Point{}, err
+		}
+		if sk.Sign() != 0 {
+			return sk, g.scalarBaseMult(sk), nil
+		}
+	}
+	return nil, Point{}, fmt.Errorf("%w: DeriveKeyPair failed", ErrInvalidPoint)
+}
+
+// blind is Blind: it returns the blind and the serialized blinded
+// element of input.
+func (g *oprfGroup) blind(r io.Reader, input []byte) (blind *big.Int, blinded []byte, err error) {
+	b, err := randomScalar(r, g.order)
+	if err != nil {
+		return
+	}
+	blind = new(big.Int).SetBytes(b)
+	blinded, err = g.blindWith(blind, input)
+	return
+}
+
+// blindWith is Blind with a given blind, for the test vectors.
+func (g *oprfGroup) blindWith(blind *big.Int, input []byte) ([]byte, error) {
+	pt, err := g.hashToGroup(input)
+	if err != nil {
+		return nil, err
+	}
+	if pt.X.Sign() == 0 && pt.Y.Sign() == 0 {
+		return nil, fmt.Errorf("%w: input hashes to the identity", ErrInvalidPoint)
+	}
+	return g.serializeElement(g.scalarMult(pt, blind)), nil
+}
+
+// blindEvaluate is BlindEvaluate: the serialized sk·blinded.
+func (g *oprfGroup) blindEvaluate(sk *big.Int, blinded []byte) ([]byte, error) {
+	pt, err := g.deserializeElement(blinded)
+	if err != nil {
+		return nil, err
+	}
+	return g.serializeElement(g.scalarMult(pt, sk)), nil
+}
+
+// finalize is Finalize: it unblinds the evaluated element and hashes
+// it with the input.
+func (g *oprfGroup) finalize(input []byte, blind *big.Int, evaluated []byte) ([]byte, error) {
+	pt, err := g.deserializeElement(evaluated)
+	if err != nil {
+		return nil, err
+	}
+	unblinded := g.serializeElement(g.scalarMult(pt, new(big.Int).ModInverse(blind, g.order)))
+	H := g.hash()
+	H.Write(i2osp2(input))
+	H.Write(i2osp2(unblinded))
+	H.Write([]byte("Finalize"))
+	return H.Sum(nil), nil
+}
+
+// i2osp2 returns b preceded by its length as a 2-byte big-endian
+// integer, which is I2OSP(len(b), 2) || b.
+func i2osp2(b []byte) []byte {
+	return append([]byte{byte(len(b) >> 8), byte(len(b))}, b...)
+}
@@ -0,0 +1,87 @@
+package pake
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"path/filepath"
+	"testing"
+)
+
+func TestOPRF(t *testing.T) {
+	// RFC 9497, appendix A, the OPRF mode
+	var suites []struct {
+		Identifier string
+		Seed       string
+		KeyInfo    string
+		SkSm       string
+		Vectors    []struct {
+			Input, Blind, BlindedElement string
+			EvaluationElement, Output    string
+		}
+	}
+	readJSON(t, filepath.Join("testdata", "opaque", "oprf.json"), &suites)
+	ids := make(map[string]string)
+	for curve, suite := range oprfSuites {
+		ids[suite.id] = curve
+	}
+	for _, suite := range suites {
+		g, err := newOPRFGroup(ids[suite.Identifier])
+		if err != nil {
+			t.Fatalf("%s: %v", suite.Identifier, err)
+		}
+		sk, _, err := g.deriveKeyPair(mustHex(t, suite.Seed), mustHex(t, suite.KeyInfo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(g.serializeScalar(sk)); got != suite.SkSm {
+			t.Errorf("%s: skSm = %s, want %s", suite.Identifier, got, suite.SkSm)
+		}
+		for _, tt := range suite.Vectors {
+			input := mustHex(t, tt.Input)
+			blind, err := g.deserializeScalar(mustHex(t, tt.Blind))
+			if err != nil {
+				t.Fatal(err)
+			}
+			blinded, err := g.blindWith(blind, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(blinded); got != tt.BlindedElement {
+				t.Errorf("%s(%s): blinded element %s, want %s", suite.Identifier, tt.Input, got, tt.BlindedElement)
+			}
+			evaluated, err := g.blindEvaluate(sk, blinded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(evaluated); got != tt.EvaluationElement {
+				t.Errorf("%s(%s): evaluation element %s, want %s", suite.Identifier, tt.Input, got, tt.EvaluationElement)
+			}
+			output, err := g.finalize(input, blind, evaluated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(output, mustHex(t, tt.Output)) {
+				t.Errorf("%s(%s): output %x, want %s", suite.Identifier, tt.Input, output, tt.Output)
+			}
+		}
+	}
+}
+
+func TestOPRFInvalidElement(t *testing.T) {
+	for curve := range oprfSuites {
+		g, err := newOPRFGroup(curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = g.blindEvaluate(big.NewInt(1), make([]byte, g.elementSize())); err == nil {
+			t.Errorf("%s: the identity should be rejected", curve)
+		}
+		if _, err = g.blindEvaluate(big.NewInt(1), []byte{2}); err == nil {
+			t.Errorf("%s: a short element should be rejected", curve)
+		}
+	}
+	if _, err := newOPRFGroup("siec"); err == nil {
+		t.Error("siec has no OPRF ciphersuite")
+	}
+}
@@ -1,16 +1,17 @@
 package pake
 
 import (
-	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"math/big"
 
 	"filippo.io/edwards25519"
-	"github.com/tscholl2/siec"
 )
 
 // EllipticCurve is a general curve which allows other
@@ -77,6 +78,25 @@ func (e *Edwards25519Curve) IsOnCurve(x, y *big.Int) bool {
 	return err == nil
 }
 
+// inPrimeOrderSubgroup reports whether the point is in the subgroup of
+// order ℓ, which excludes the identity and the other torsion points as
+// well as any point with a torsion component.
+func (e *Edwards25519Curve) inPrimeOrderSubgroup(x, y *big.Int) bool {
+	point, err := (&edwards25519.Point{}).SetBytes(ed25519PointFromBigInts(x, y))
+	if err != nil {
+		return false
+	}
+	identity := edwards25519.NewIdentityPoint()
+	if (&edwards25519.Point{}).MultByCofactor(point).Equal(identity) == 1 {
+		return false
+	}
+	// ℓ·P = (ℓ-1)·P + P must be the identity
+	lMinusOne := edwards25519.NewScalar().Subtract(edwards25519.NewScalar(), ed25519Scalar([]byte{1}))
+	lP := (&edwards25519.Point{}).ScalarMult(lMinusOne, point)
+	lP.Add(lP, point)
+	return lP.Equal(identity) == 1
+}
+
 // normalizeScalar ensures the scalar is exactly 32 bytes
 func normalizeScalar(k []byte) []byte {
 	key := make([]byte, 32)
@@ -88,6 +108,69 @@ func normalizeScalar(k []byte) []byte {
 	return key
 }
 
+// ed25519Order is the order of the prime-order subgroup of Edwards25519,
+// 2^252 + 27742317777372353535851937790883648493
+var ed25519Order, _ = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+
+// ed25519Scalar reduces the big-endian integer k modulo the group order.
+func ed25519Scalar(k []byte) *edwards25519.Scalar {
+	n := new(big.Int).SetBytes(k)
+	n.Mod(n, ed25519Order)
+	b := n.FillBytes(make([]byte, 32))
+	reverseBytes(b)
+	scalar, _ := edwards25519.NewScalar().SetCanonicalBytes(b)
+	return scalar
+}
+
+// scalarMult returns k·(x,y) for the big-endian integer k. Unlike
+// Edwards25519Curve.ScalarMult, k is neither truncated nor clamped.
+func scalarMult(curve EllipticCurve, x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	if _, ok := curve.(*Edwards25519Curve); ok {
+		point, err := (&edwards25519.Point{}).SetBytes(ed25519PointFromBigInts(x, y))
+		if err != nil {
+			return big.NewInt(0), big.NewInt(0)
+		}
+		result := (&edwards25519.Point{}).ScalarMult(ed25519Scalar(k), point)
+		return ed25519PointToBigInts(result.Bytes())
+	}
+	return curve.ScalarMult(x, y, k)
+}
+
+// scalarBaseMult returns k·G for the big-endian integer k. Unlike
+// Edwards25519Curve.ScalarBaseMult, k is neither truncated nor clamped.
+func scalarBaseMult(curve EllipticCurve, k []byte) (*big.Int, *big.Int) {
+	if _, ok := curve.(*Edwards25519Curve); ok {
+		result := (&edwards25519.Point{}).ScalarBaseMult(ed25519Scalar(k))
+		return ed25519PointToBigInts(result.Bytes())
+	}
+	return curve.ScalarBaseMult(k)
+}
+
+// curveCofactor returns the cofactor of curve.
+func curveCofactor(curve EllipticCurve) int {
+	if _, ok := curve.(*Edwards25519Curve); ok {
+		return 8
+	}
+	return 1
+}
+
+// randomScalar reads a uniformly random non-zero scalar modulo
+// order, big-endian and padded to the length of order.
+func randomScalar(r io.Reader, order *big.Int) ([]byte, error) {
+	size := (order.BitLen() + 7) / 8
+	b := make([]byte, size+16)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		k := new(big.Int).SetBytes(b)
+		k.Mod(k, order)
+		if k.Sign() != 0 {
+			return k.FillBytes(make([]byte, size)), nil
+		}
+	}
+}
+
 // ed25519PointFromBigInts converts big.Int coordinates back to Edwards25519 point bytes
 func ed25519PointFromBigInts(x, y *big.Int) []byte {
 	// The point is stored entirely in x, y is ignored for Edwards25519
@@ -121,10 +204,19 @@ func ed25519PointToBigInts(pointBytes []byte) (*big.Int, *big.Int) {
 type Pake struct {
 	// Public variables
 	Role   int
+	Curve  string `json:",omitempty"`
 	Uᵤ, Uᵥ *big.Int
 	Vᵤ, Vᵥ *big.Int
 	Xᵤ, Xᵥ *big.Int
 	Yᵤ, Yᵥ *big.Int
+	Offers []Offer `json:",omitempty"`
+	// Version is the transcript version: the highest one offered by
+	// role 0, and the one chosen by role 1. Peers that do not send it
+	// only support version 1.
+	Version int `json:",omitempty"`
+	// Mode is the protocol variant. Peers that do not send it use
+	// ModeLegacy.
+	Mode Mode `json:",omitempty"`
 
 	// Private variables
 	curve      EllipticCurve
@@ -136,127 +228,388 @@ type Pake struct {
 	Aαᵤ, Aαᵥ   *big.Int
 	Zᵤ, Zᵥ     *big.Int
 	K          []byte
+
+	// parameters of the protocol variant
+	rand     io.Reader
+	hash     func() hash.Hash
+	order    *big.Int // the order of the prime-order subgroup
+	custom   bool     // the curve was registered with RegisterCurve
+	idA, idB []byte
+	w        []byte // the password scalar w (w0 for SPAKE2+)
+
+	// SPAKE2+ state: w1 is only known to the client and L = w1·G only
+	// to the server, both compute V
+	w1           []byte
+	lᵤ, lᵥ       *big.Int
+	augVᵤ, augVᵥ *big.Int
+
+	// CPace state: the generator derived from the password, which must
+	// stay secret, the session ID and the associated data ADa and ADb
+	gᵤ, gᵥ   *big.Int
+	sid      []byte
+	adA, adB []byte
+
+	// the transcript version offered by role 0, which selects the
+	// password encoding and is bound into the keys with the chosen one
+	offeredVersion int
+
+	// key confirmation state, derived together with K
+	transcript []byte
+	kcA, kcB   []byte
+
+	// curve negotiation state, and the offered curves once negotiated,
+	// which are bound into the keys
+	negotiation *negotiation
+	aad         []byte
 }
 
+// Transcript versions of the legacy mode. Version 1 hashes the
+// coordinates with big.Int.Bytes, which are variable-length and not
+// length-prefixed. Version 2 encodes each element at the fixed width of
+// the curve, with length prefixes.
+const (
+	transcriptVersion1      = 1
+	transcriptVersion2      = 2
+	latestTranscriptVersion = transcriptVersion2
+)
+
 // Public returns the public variables of Pake
 func (p *Pake) Public() *Pake {
+	if p == nil {
+		return nil
+	}
 	return &Pake{
-		Role: p.Role,
-		Uᵤ:   p.Uᵤ,
-		Uᵥ:   p.Uᵥ,
-		Vᵤ:   p.Vᵤ,
-		Vᵥ:   p.Vᵥ,
-		Xᵤ:   p.Xᵤ,
-		Xᵥ:   p.Xᵥ,
-		Yᵤ:   p.Yᵤ,
-		Yᵥ:   p.Yᵥ,
+		Role:    p.Role,
+		Curve:   p.Curve,
+		Uᵤ:      p.Uᵤ,
+		Uᵥ:      p.Uᵥ,
+		Vᵤ:      p.Vᵤ,
+		Vᵥ:      p.Vᵥ,
+		Xᵤ:      p.Xᵤ,
+		Xᵥ:      p.Xᵥ,
+		Yᵤ:      p.Yᵤ,
+		Yᵥ:      p.Yᵥ,
+		Offers:  p.Offers,
+		Version: p.Version,
+		Mode:    p.Mode,
 	}
 }
 
-// AvailableCurves returns available curves
-func AvailableCurves() []string {
-	return []string{"p521", "p256", "p384", "siec", "ed25519"}
+// Mode selects the protocol variant used to derive the session key.
+type Mode int
+
+const (
+	// ModeLegacy is the original derivation of this package,
+	// K = H(pw, A, B, X, Y, Z), using the hard-coded U and V points.
+	// The identities A and B are only hashed when at least one is set.
+	ModeLegacy Mode = iota
+	// ModeRFC9382 follows SPAKE2 as specified in RFC 9382, using the
+	// standard M and N points and the transcript TT to derive Ke, Ka
+	// and the confirmation keys KcA and KcB. It is available for
+	// p256, p384, p521 and ed25519.
+	ModeRFC9382
+	// ModeSPAKE2Plus is a variant of the augmented SPAKE2+ protocol
+	// that is specific to this package: it derives w0 and w1 and the
+	// transcript its own way and does not interoperate with RFC 9383.
+	// Role 0 is the client (prover) and role 1 the server (verifier),
+	// which can be initialized from a Verifier with InitVerifier
+	// instead of the password. It supports the curves of ModeRFC9382.
+	ModeSPAKE2Plus
+	// ModeCPace is the CPace protocol of draft-irtf-cfrg-cpace, which
+	// needs no U and V points: both parties hash the password, the
+	// identities and Options.SessionID to a secret generator, exchange
+	// multiples of it and derive the session key ISK from the shared
+	// point and the transcript. It is only available for ristretto255,
+	// whose map to the group is constant time.
+	ModeCPace
+)
+
+// Options configures a Pake created with InitCurveWithOptions.
+type Options struct {
+	// Mode selects the protocol variant, ModeLegacy by default.
+	Mode Mode
+
+	// LocalIdentity and RemoteIdentity name this party and the other
+	// party. They are bound into the transcript in role order, so the
+	// identity of role 0 is A and the identity of role 1 is B.
+	LocalIdentity  string
+	RemoteIdentity string
+
+	// TranscriptVersion is the highest transcript version of the
+	// legacy mode to use. Role 0 offers version 1 by default: the offer
+	// is bound into the keys, and a role 1 that predates the
+	// negotiation ignores it and answers with version 1. Setting it to
+	// 2 opts into the fixed-width transcript, which role 1 accepts by
+	// default. An offer of version 1 also keeps the original password
+	// encoding of ed25519 on both sides, which only uses the first 32
+	// bytes of the password.
+	TranscriptVersion int
+
+	// Rand is the source of the random secret, crypto/rand.Reader by
+	// default. It should only be set for reproducible tests.
+	Rand io.Reader
+
+	// HashPassword makes the legacy mode hash the password to a scalar
+	// modulo the group order instead of reading it as a big-endian
+	// integer modulo the group order. Both parties must agree on it.
+	// The other modes always hash the password.
+	HashPassword bool
+
+	// Argon2 stretches the password with Argon2id before it is hashed,
+	// in every mode, which slows down dictionary attacks on a captured
+	// transcript or Verifier. It implies HashPassword.
+	Argon2 *Argon2Params
+
+	// SessionID is the session identifier sid of ModeCPace, e.g. a
+	// nonce both parties agreed on beforehand, which is bound into the
+	// generator and the session key. It may be empty.
+	SessionID []byte
+
+	// LocalAssociatedData and RemoteAssociatedData are the associated
+	// data of ModeCPace, in role order like the identities: the data of
+	// role 0 is ADa and the data of role 1 is ADb. Both are bound into
+	// the session key, so both parties must agree on them. They may be
+	// empty.
+	LocalAssociatedData  []byte
+	RemoteAssociatedData []byte
+
+	// Context is the application context of OPAQUE, which is bound
+	// into the transcript. Both parties must use the same.
+	Context []byte
+}
+
+// reader returns the source of randomness selected by o.
+func (o Options) reader() io.Reader {
+	if o.Rand == nil {
+		return rand.Reader
+	}
+	return o.Rand
 }
 
-// InitCurve will take the secret weak passphrase (pw) to initialize
+// Init will take the secret weak passphrase (pw) to initialize
 // the points on the elliptic curve. The role is set to either
 // 0 for the sender or 1 for the recipient.
-// The curve can be siec,  p521, p256, p384
-func initCurve(curve string) (ellipticCurve EllipticCurve, P *big.Int, Ux *big.Int, Uy *big.Int, Vx *big.Int, Vy *big.Int, err error) {
-	switch curve {
-	case "p521":
-		ellipticCurve = elliptic.P521()
-		Ux, _ = new(big.Int).SetString("793136080485469241208656611513609866400481671852", 10)
-		Uy, _ = new(big.Int).SetString("4032821203812196944795502391345776760852202059010382256134592838722123385325802540879231526503456158741518531456199762365161310489884151533417829496019094620", 10)
-		Vx, _ = new(big.Int).SetString("1086685267857089638167386722555472967068468061489", 10)
-		Vy, _ = new(big.Int).SetString("5010916268086655347194655708160715195931018676225831839835602465999566066450501167246678404591906342753230577187831311039273858772817427392089150297708931207", 10)
-		P = elliptic.P521().Params().P
-	case "p256":
-		ellipticCurve = elliptic.P256()
-		Ux, _ = new(big.Int).SetString("793136080485469241208656611513609866400481671852", 10)
-		Uy, _ = new(big.Int).SetString("59748757929350367369315811184980635230185250460108398961713395032485227207304", 10)
-		Vx, _ = new(big.Int).SetString("1086685267857089638167386722555472967068468061489", 10)
-		Vy, _ = new(big.Int).SetString("9157340230202296554417312816309453883742349874205386245733062928888341584123", 10)
-		P = elliptic.P256().Params().P
-	case "p384":
-		ellipticCurve = elliptic.P384()
-		Ux, _ = new(big.Int).SetString("793136080485469241208656611513609866400481671852", 10)
-		Uy, _ = new(big.Int).SetString("7854890799382392388170852325516804266858248936799429260403044177981810983054351714387874260245230531084533936948596", 10)
-		Vx, _ = new(big.Int).SetString("1086685267857089638167386722555472967068468061489", 10)
-		Vy, _ = new(big.Int).SetString("21898206562669911998235297167979083576432197282633635629145270958059347586763418294901448537278960988843108277491616", 10)
-		P = elliptic.P384().Params().P
-	case "siec":
-		ellipticCurve = siec.SIEC255()
-		Ux, _ = new(big.Int).SetString("793136080485469241208656611513609866400481671853", 10)
-		Uy, _ = new(big.Int).SetString("18458907634222644275952014841865282643645472623913459400556233196838128612339", 10)
-		Vx, _ = new(big.Int).SetString("1086685267857089638167386722555472967068468061489", 10)
-		Vy, _ = new(big.Int).SetString("19593504966619549205903364028255899745298716108914514072669075231742699650911", 10)
-		P = siec.SIEC255().Params().P
-	case "ed25519":
-		ellipticCurve = &Edwards25519Curve{}
-		// Use fixed valid Edwards25519 points generated from "croc1" and "croc2" seeds
-		Ux, _ = new(big.Int).SetString("41821174510521985817056358996007359290163947216650231187782646151092828043509", 10)
-		Uy, _ = new(big.Int).SetString("0", 10)
-		Vx, _ = new(big.Int).SetString("1456941786990260824647297143563623381366314063537015067473110401627488371271", 10)
-		Vy, _ = new(big.Int).SetString("0", 10)
-		// 2^255 - 19
-		P, _ = new(big.Int).SetString("57896044618658097711785492504343953926634992332820282019728792003956564819949", 10)
-	default:
-		err = errors.New("no such curve")
+// The curve can be any elliptic curve.
+func InitCurve(pw []byte, role int, curve string) (p *Pake, err error) {
+	return InitCurveWithOptions(pw, role, curve, Options{})
+}
+
+// InitCurveWithOptions is like InitCurve, but allows selecting the
+// protocol variant and the identities of both parties. When identities
+// are given, both parties must agree on them or they derive different
+// keys and key confirmation fails.
+func InitCurveWithOptions(pw []byte, role int, curve string, opts Options) (p *Pake, err error) {
+	return initPassword(pw, stretchPassword(pw, opts), role, curve, opts)
+}
+
+// initPassword is InitCurveWithOptions with the password already
+// stretched, so that curve negotiation stretches it only once.
+func initPassword(pw, stretched []byte, role int, curve string, opts Options) (p *Pake, err error) {
+	p, err = newPake(role, curve, opts)
+	if err != nil {
 		return
 	}
-	if err == nil {
-		if !ellipticCurve.IsOnCurve(Ux, Uy) {
-			err = fmt.Errorf("Ux/Uy not on curve")
-		}
-		if !ellipticCurve.IsOnCurve(Vx, Vy) {
-			err = fmt.Errorf("Vx/Vy not on curve")
+	p.Pw = pw
+	switch p.Mode {
+	case ModeLegacy:
+		if opts.hashesPassword() {
+			err = p.initPasswordHash(stretched)
 		}
+	case ModeRFC9382:
+		err = p.initRFC9382(curve, stretched)
+	case ModeSPAKE2Plus:
+		err = p.initSPAKE2Plus(curve, stretched)
+	case ModeCPace:
+		// CPace computes its own X
+		err = p.initCPace(curve, stretched, opts)
+		return
+	default:
+		err = ErrUnknownMode
+	}
+	if err != nil {
+		return
 	}
 
+	if p.Role == 0 {
+		// STEP: A computes X
+		p.Vpwᵤ, p.Vpwᵥ = p.multiplyPassword(p.Vᵤ, p.Vᵥ)
+		p.Upwᵤ, p.Upwᵥ = p.multiplyPassword(p.Uᵤ, p.Uᵥ)
+		err = p.generateSecret()
+		if err != nil {
+			return
+		}
+		p.Xᵤ, p.Xᵥ = p.curve.Add(p.Upwᵤ, p.Upwᵥ, p.Aαᵤ, p.Aαᵥ) // "X"
+		// now X should be sent to B
+	}
 	return
 }
 
-// Init will take the secret weak passphrase (pw) to initialize
-// the points on the elliptic curve. The role is set to either
-// 0 for the sender or 1 for the recipient.
-// The curve can be any elliptic curve.
-func InitCurve(pw []byte, role int, curve string) (p *Pake, err error) {
+// newPake sets up the curve, role and identities shared by all modes.
+func newPake(role int, curve string, opts Options) (p *Pake, err error) {
 	p = new(Pake)
 	p.curve, p.P, p.Uᵤ, p.Uᵥ, p.Vᵤ, p.Vᵥ, err = initCurve(curve)
 	if err != nil {
 		return
 	}
-	p.Pw = pw
+	spec, custom, _ := lookupCurve(curve)
+	p.order, p.custom = spec.Order, custom
+	p.Curve = curve
+	p.Mode = opts.Mode
+	switch opts.TranscriptVersion {
+	case 0:
+		p.Version = transcriptVersion1
+		if role == 1 {
+			p.Version = latestTranscriptVersion
+		}
+	case transcriptVersion1, transcriptVersion2:
+		p.Version = opts.TranscriptVersion
+	default:
+		return nil, fmt.Errorf("%w: transcript version %d", ErrUnsupportedVersion, opts.TranscriptVersion)
+	}
+	p.rand = opts.reader()
+	p.hash = sha256.New
 	if role == 1 {
 		p.Role = 1
+		p.idA, p.idB = []byte(opts.RemoteIdentity), []byte(opts.LocalIdentity)
 	} else {
 		p.Role = 0
+		p.idA, p.idB = []byte(opts.LocalIdentity), []byte(opts.RemoteIdentity)
+		p.offeredVersion = p.Version
+	}
+	return
+}
 
-		// STEP: A computes X
-		p.Vpwᵤ, p.Vpwᵥ = p.curve.ScalarMult(p.Vᵤ, p.Vᵥ, p.Pw)
-		p.Upwᵤ, p.Upwᵥ = p.curve.ScalarMult(p.Uᵤ, p.Uᵥ, p.Pw)
+// multiplyPassword returns the password multiple of the given point.
+func (p *Pake) multiplyPassword(x, y *big.Int) (*big.Int, *big.Int) {
+	if p.Mode == ModeLegacy && p.w == nil {
+		if p.offeredVersion == transcriptVersion1 {
+			// the original encoding, which truncates the password to
+			// 32 bytes and clamps it on ed25519. It depends on the offer
+			// rather than on the agreed version, which role 0 does not
+			// know yet when it computes X.
+			return p.curve.ScalarMult(x, y, p.Pw)
+		}
+		return scalarMult(p.curve, x, y, p.passwordScalar())
+	}
+	return scalarMult(p.curve, x, y, p.w)
+}
+
+// generateSecret picks the random secret α and computes α·G.
+func (p *Pake) generateSecret() (err error) {
+	if p.Mode == ModeLegacy {
 		p.Aα = make([]byte, 32) // randomly generated secret
-		_, err = rand.Read(p.Aα)
+		_, err = io.ReadFull(p.rand, p.Aα)
 		if err != nil {
 			return
 		}
 		p.Aαᵤ, p.Aαᵥ = p.curve.ScalarBaseMult(p.Aα)
-		p.Xᵤ, p.Xᵥ = p.curve.Add(p.Upwᵤ, p.Upwᵥ, p.Aαᵤ, p.Aαᵥ) // "X"
-		// now X should be sent to B
+		return
+	}
+	p.Aα, err = randomScalar(p.rand, p.order)
+	if err != nil {
+		return
 	}
+	p.Aαᵤ, p.Aαᵥ = scalarBaseMult(p.curve, p.Aα)
 	return
 }
 
+// multiplySecret returns the secret multiple of the given point,
+// including the cofactor where the protocol variant requires it.
+func (p *Pake) multiplySecret(x, y *big.Int) (*big.Int, *big.Int) {
+	if p.Mode == ModeLegacy {
+		return p.curve.ScalarMult(x, y, p.Aα)
+	}
+	x, y = scalarMult(p.curve, x, y, p.Aα)
+	if h := curveCofactor(p.curve); h > 1 {
+		x, y = scalarMult(p.curve, x, y, []byte{byte(h)})
+	}
+	return x, y
+}
+
+// validatePoint checks the point named name received from the other
+// party. It must be on the curve and must not be the identity (the
+// point at infinity) or, for Edwards25519 and registered curves, be
+// outside of the prime-order subgroup. The other curves have prime
+// order.
+func (p *Pake) validatePoint(x, y *big.Int, name string) error {
+	if x == nil || y == nil {
+		return fmt.Errorf("%w: %s values missing", ErrInvalidPoint, name)
+	}
+	if !p.inRange(x, y) {
+		return fmt.Errorf("%w: %s values out of range", ErrInvalidPoint, name)
+	}
+	// (0, 0) is the point at infinity of the Weierstrass curves
+	// and the identity of ristretto255
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return ErrSmallOrderPoint
+	}
+	if !p.curve.IsOnCurve(x, y) {
+		return fmt.Errorf("%w: %s values not on curve", ErrInvalidPoint, name)
+	}
+	if ed25519Curve, ok := p.curve.(*Edwards25519Curve); ok && !ed25519Curve.inPrimeOrderSubgroup(x, y) {
+		return ErrSmallOrderPoint
+	}
+	if p.custom && !inSubgroup(p.curve, p.order, x, y) {
+		return ErrSmallOrderPoint
+	}
+	return nil
+}
+
+// inRange reports whether x and y are canonical: reduced modulo the
+// field order or, for Edwards25519 and ristretto255, a 32-byte encoding
+// in x and zero in y.
+func (p *Pake) inRange(x, y *big.Int) bool {
+	switch p.curve.(type) {
+	case *Edwards25519Curve, *Ristretto255Curve:
+		return x.Sign() >= 0 && x.BitLen() <= 256 && y.Sign() == 0
+	}
+	return x.Sign() >= 0 && y.Sign() >= 0 && x.Cmp(p.P) < 0 && y.Cmp(p.P) < 0
+}
+
+// subtracter is implemented by curves whose points cannot be
+// negated through the y coordinate.
+type subtracter interface {
+	Subtract(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int)
+}
+
+// subtract returns (x1,y1) - (x2,y2).
+func (p *Pake) subtract(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	if curve, ok := p.curve.(subtracter); ok {
+		// For Edwards25519 and ristretto255, use proper subtraction
+		return curve.Subtract(x1, y1, x2, y2)
+	}
+	// For other curves, use the original negation method
+	v := new(big.Int).Neg(y2)
+	v.Mod(v, p.P)
+	return p.curve.Add(x1, y1, x2, v)
+}
+
+// encodePoint returns the fixed-width uncompressed encoding of (x,y),
+// i.e. the 32-byte encoding for Edwards25519 and ristretto255 and 0x04 || x || y
+// padded to the field size for the other curves.
+func (p *Pake) encodePoint(x, y *big.Int) []byte {
+	switch p.curve.(type) {
+	case *Edwards25519Curve, *Ristretto255Curve:
+		return ed25519PointFromBigInts(x, y)
+	}
+	size := (p.P.BitLen() + 7) / 8
+	b := make([]byte, 1+2*size)
+	b[0] = 4
+	x.FillBytes(b[1 : 1+size])
+	y.FillBytes(b[1+size:])
+	return b
+}
+
 // Bytes just marshalls the PAKE structure so that
 // private variables are hidden.
+// It returns nil for a nil Pake, which Update rejects.
 func (p *Pake) Bytes() (b []byte) {
 	if p == nil {
-		panic("pake is not initialized")
+		return nil
 	}
 	b, err := json.Marshal(p.Public())
 	if err != nil {
-		panic(err)
+		return nil
 	}
 	return
 }
@@ -265,107 +618,273 @@ func (p *Pake) Bytes() (b []byte) {
 // PAKE and automatically determine what stage
 // and what to generate.
 func (p *Pake) Update(qBytes []byte) (err error) {
-	if p == nil {
-		err = fmt.Errorf("pake is not initialized")
+	if p == nil || (p.curve == nil && p.negotiation == nil) {
+		err = ErrNotInitialized
 		return
 	}
-	var q *Pake
-	err = json.Unmarshal(qBytes, &q)
+	q, err := decodeMessage(qBytes, p.Mode)
 	if err != nil {
 		return
 	}
 	if p.Role == q.Role {
-		err = errors.New("can't have its own role")
+		err = ErrRoleConflict
+		return
+	}
+	if p.negotiation != nil {
+		if err = p.negotiate(q); err != nil {
+			return
+		}
+	}
+	if err = p.checkCurve(q); err != nil {
 		return
 	}
+	if err = p.agreeVersion(q); err != nil {
+		return
+	}
+	if p.Mode == ModeCPace {
+		return p.updateCPace(q)
+	}
 
 	if p.Role == 1 {
 		// copy over public variables
 		p.Xᵤ, p.Xᵥ = q.Xᵤ, q.Xᵥ
 
-		// confirm that X is on curve
-		if !p.curve.IsOnCurve(p.Xᵤ, p.Xᵥ) {
-			err = errors.New("X values not on curve")
+		// confirm that X is on curve and of prime order
+		if err = p.validatePoint(p.Xᵤ, p.Xᵥ, "X"); err != nil {
 			return
 		}
 
 		// STEP: B computes Y
-		p.Vpwᵤ, p.Vpwᵥ = p.curve.ScalarMult(p.Vᵤ, p.Vᵥ, p.Pw)
-		p.Upwᵤ, p.Upwᵥ = p.curve.ScalarMult(p.Uᵤ, p.Uᵥ, p.Pw)
-		p.Aα = make([]byte, 32) // randomly generated secret
-		rand.Read(p.Aα)
-		p.Aαᵤ, p.Aαᵥ = p.curve.ScalarBaseMult(p.Aα)
+		p.Vpwᵤ, p.Vpwᵥ = p.multiplyPassword(p.Vᵤ, p.Vᵥ)
+		p.Upwᵤ, p.Upwᵥ = p.multiplyPassword(p.Uᵤ, p.Uᵥ)
+		err = p.generateSecret()
+		if err != nil {
+			return
+		}
 		p.Yᵤ, p.Yᵥ = p.curve.Add(p.Vpwᵤ, p.Vpwᵥ, p.Aαᵤ, p.Aαᵥ) // "Y"
 		// STEP: B computes Z
-		if ed25519Curve, ok := p.curve.(*Edwards25519Curve); ok {
-			// For Edwards25519, use proper subtraction
-			p.Zᵤ, p.Zᵥ = ed25519Curve.Subtract(p.Xᵤ, p.Xᵥ, p.Upwᵤ, p.Upwᵥ)
-		} else {
-			// For other curves, use the original negation method
-			v := new(big.Int).Neg(p.Upwᵥ)
-			v.Mod(v, p.P)
-			p.Zᵤ, p.Zᵥ = p.curve.Add(p.Xᵤ, p.Xᵥ, p.Upwᵤ, v)
+		p.Zᵤ, p.Zᵥ = p.subtract(p.Xᵤ, p.Xᵥ, p.Upwᵤ, p.Upwᵥ)
+		if p.Mode == ModeSPAKE2Plus {
+			// V = h·y·L
+			p.augVᵤ, p.augVᵥ = p.multiplySecret(p.lᵤ, p.lᵥ)
 		}
-		p.Zᵤ, p.Zᵥ = p.curve.ScalarMult(p.Zᵤ, p.Zᵥ, p.Aα)
-		// STEP: B computes k
-		// H(pw,id_P,id_Q,X,Y,Z)
-		HB := sha256.New()
-		HB.Write(p.Pw)
-		HB.Write(p.Xᵤ.Bytes())
-		HB.Write(p.Xᵥ.Bytes())
-		HB.Write(p.Yᵤ.Bytes())
-		HB.Write(p.Yᵥ.Bytes())
-		HB.Write(p.Zᵤ.Bytes())
-		HB.Write(p.Zᵥ.Bytes())
-		// STEP: B computes k
-		p.K = HB.Sum(nil)
 	} else {
 		p.Yᵤ, p.Yᵥ = q.Yᵤ, q.Yᵥ
 
-		// confirm that Y is on curve
-		if !p.curve.IsOnCurve(p.Yᵤ, p.Yᵥ) {
-			err = errors.New("Y values not on curve")
+		// confirm that Y is on curve and of prime order
+		if err = p.validatePoint(p.Yᵤ, p.Yᵥ, "Y"); err != nil {
 			return
 		}
 
 		// STEP: A computes Z
-		if ed25519Curve, ok := p.curve.(*Edwards25519Curve); ok {
-			// For Edwards25519, use proper subtraction
-			p.Zᵤ, p.Zᵥ = ed25519Curve.Subtract(p.Yᵤ, p.Yᵥ, p.Vpwᵤ, p.Vpwᵥ)
-		} else {
-			// For other curves, use the original negation method
-			v := new(big.Int).Neg(p.Vpwᵥ)
-			v.Mod(v, p.P)
-			p.Zᵤ, p.Zᵥ = p.curve.Add(p.Yᵤ, p.Yᵥ, p.Vpwᵤ, v)
+		p.Zᵤ, p.Zᵥ = p.subtract(p.Yᵤ, p.Yᵥ, p.Vpwᵤ, p.Vpwᵥ)
+		if p.Mode == ModeSPAKE2Plus {
+			// V = h·w1·(Y - w0·N)
+			p.augVᵤ, p.augVᵥ = p.multiplyW1(p.Zᵤ, p.Zᵥ)
 		}
-		p.Zᵤ, p.Zᵥ = p.curve.ScalarMult(p.Zᵤ, p.Zᵥ, p.Aα)
-		// STEP: A computes k
-		// H(pw,id_P,id_Q,X,Y,Z)
-		HA := sha256.New()
-		HA.Write(p.Pw)
-		HA.Write(p.Xᵤ.Bytes())
-		HA.Write(p.Xᵥ.Bytes())
-		HA.Write(p.Yᵤ.Bytes())
-		HA.Write(p.Yᵥ.Bytes())
-		HA.Write(p.Zᵤ.Bytes())
-		HA.Write(p.Zᵥ.Bytes())
-		p.K = HA.Sum(nil)
 	}
+	p.Zᵤ, p.Zᵥ = p.multiplySecret(p.Zᵤ, p.Zᵥ)
+
+	// STEP: both compute k
+	switch p.Mode {
+	case ModeRFC9382:
+		return p.deriveRFC9382Keys()
+	case ModeSPAKE2Plus:
+		return p.deriveSPAKE2PlusKeys()
+	}
+	if p.Version == transcriptVersion1 {
+		p.deriveLegacyKeysV1()
+		return
+	}
+	p.deriveLegacyKeysV2()
 	return
 }
 
+// deriveLegacyKeysV1 derives the keys of transcript version 1.
+func (p *Pake) deriveLegacyKeysV1() {
+	// H(pw,id_P,id_Q,X,Y,Z)
+	H := sha256.New()
+	H.Write(p.Pw)
+	if p.offeredVersion > transcriptVersion1 {
+		// role 0 offered a later version, which a forced downgrade
+		// to version 1 would have removed
+		H.Write(appendLengthPrefixed(nil, []byte{byte(p.offeredVersion), byte(p.Version)}))
+	}
+	if len(p.idA) > 0 || len(p.idB) > 0 {
+		// the identities are only hashed when set, so that
+		// peers without identities keep deriving the same key
+		H.Write(appendLengthPrefixed(nil, p.idA, p.idB))
+	}
+	if len(p.aad) > 0 {
+		// the offered curves, when the curve was negotiated
+		H.Write(p.aad)
+	}
+	H.Write(p.Xᵤ.Bytes())
+	H.Write(p.Xᵥ.Bytes())
+	H.Write(p.Yᵤ.Bytes())
+	H.Write(p.Yᵥ.Bytes())
+	H.Write(p.Zᵤ.Bytes())
+	H.Write(p.Zᵥ.Bytes())
+	p.K = H.Sum(nil)
+	p.deriveConfirmationKeys()
+}
+
+// deriveLegacyKeysV2 derives the keys of transcript version 2, which
+// encodes every element at a fixed width and length-prefixes it:
+// K = H(version label, offered and chosen versions, pw, id_P, id_Q,
+// offered curves, X, Y, Z).
+func (p *Pake) deriveLegacyKeysV2() {
+	H := sha256.New()
+	H.Write(appendLengthPrefixed(nil,
+		[]byte("pake-v3 transcript v2"),
+		[]byte{byte(p.offeredVersion), byte(p.Version)},
+		p.Pw,
+		p.idA, p.idB,
+		p.aad,
+		p.encodePoint(p.Xᵤ, p.Xᵥ),
+		p.encodePoint(p.Yᵤ, p.Yᵥ),
+		p.encodePoint(p.Zᵤ, p.Zᵥ),
+	))
+	p.K = H.Sum(nil)
+	p.deriveConfirmationKeys()
+}
+
+// agreeVersion settles on the transcript version: role 1 picks the
+// highest version supported by both parties and role 0 takes the one
+// chosen by role 1, which must not be higher than what it offered.
+// Role 1 remembers the offer, which selects the password encoding and
+// which the keys bind so that a peer cannot be talked down to version 1
+// by rewriting it.
+func (p *Pake) agreeVersion(q *Pake) error {
+	version := q.Version
+	if version == 0 {
+		version = transcriptVersion1
+	}
+	if p.Role == 1 {
+		p.offeredVersion = version
+		if version < p.Version {
+			p.Version = version
+		}
+		return nil
+	}
+	if version > p.Version {
+		return fmt.Errorf("%w: transcript version %d was not offered", ErrUnsupportedVersion, version)
+	}
+	p.Version = version
+	return nil
+}
+
+// checkCurve checks that the curve and the U and V points advertised
+// by the other party are the ones of p. Older peers do not send the
+// curve name and binary messages do not carry U and V, so only the
+// values present in the message are compared.
+func (p *Pake) checkCurve(q *Pake) error {
+	if q.Curve != "" && q.Curve != p.Curve {
+		return ErrCurveMismatch
+	}
+	if !equalPoint(q.Uᵤ, q.Uᵥ, p.Uᵤ, p.Uᵥ) || !equalPoint(q.Vᵤ, q.Vᵥ, p.Vᵤ, p.Vᵥ) {
+		return ErrCurveMismatch
+	}
+	return nil
+}
+
+// equalPoint reports whether (x1, y1) is absent or equal to (x2, y2),
+// which is absent in CPace mode.
+func equalPoint(x1, y1, x2, y2 *big.Int) bool {
+	if x1 == nil && y1 == nil {
+		return true
+	}
+	return x1 != nil && y1 != nil && x2 != nil && y2 != nil && x1.Cmp(x2) == 0 && y1.Cmp(y2) == 0
+}
+
+// deriveConfirmationKeys computes the transcript of the exchanged
+// points and the confirmation keys of both roles from K.
+func (p *Pake) deriveConfirmationKeys() {
+	if p.Version == transcriptVersion1 {
+		p.transcript = appendLengthPrefixed(nil,
+			p.idA, p.idB,
+			p.Xᵤ.Bytes(), p.Xᵥ.Bytes(),
+			p.Yᵤ.Bytes(), p.Yᵥ.Bytes(),
+		)
+	} else {
+		p.transcript = appendLengthPrefixed(nil,
+			p.idA, p.idB,
+			p.encodePoint(p.Xᵤ, p.Xᵥ),
+			p.encodePoint(p.Yᵤ, p.Yᵥ),
+		)
+	}
+	p.kcA = hmacSum(sha256.New, p.K, []byte("pake confirmation A"))
+	p.kcB = hmacSum(sha256.New, p.K, []byte("pake confirmation B"))
+}
+
+// confirmationTag is the MAC over the transcript sent by the given role.
+// In SPAKE2+ each role instead MACs the share of the other role.
+func (p *Pake) confirmationTag(role int) []byte {
+	if p.Mode == ModeSPAKE2Plus {
+		if role == 0 {
+			return hmacSum(p.hash, p.kcA, p.encodePoint(p.Yᵤ, p.Yᵥ))
+		}
+		return hmacSum(p.hash, p.kcB, p.encodePoint(p.Xᵤ, p.Xᵥ))
+	}
+	if role == 0 {
+		return hmacSum(p.hash, p.kcA, p.transcript)
+	}
+	return hmacSum(p.hash, p.kcB, p.transcript)
+}
+
+// Confirmation returns the key confirmation tag that should be sent
+// to the other party after Update has generated the session key.
+// The other party checks it with VerifyConfirmation.
+func (p *Pake) Confirmation() ([]byte, error) {
+	if p == nil {
+		return nil, ErrNotInitialized
+	}
+	if p.K == nil {
+		return nil, ErrNoSessionKey
+	}
+	return p.confirmationTag(p.Role), nil
+}
+
+// VerifyConfirmation checks the key confirmation tag received from
+// the other party. It returns ErrConfirmationFailed if the other
+// party derived a different session key, in which case the session
+// key must not be used.
+func (p *Pake) VerifyConfirmation(tag []byte) error {
+	if p == nil {
+		return ErrNotInitialized
+	}
+	if p.K == nil {
+		return ErrNoSessionKey
+	}
+	if !hmac.Equal(tag, p.confirmationTag(1-p.Role)) {
+		return ErrConfirmationFailed
+	}
+	return nil
+}
+
+// appendLengthPrefixed appends each field to b, preceded by its
+// length as an 8-byte little-endian integer.
+func appendLengthPrefixed(b []byte, fields ...[]byte) []byte {
+	var n [8]byte
+	for _, f := range fields {
+		binary.LittleEndian.PutUint64(n[:], uint64(len(f)))
+		b = append(b, n[:]...)
+		b = append(b, f...)
+	}
+	return b
+}
+
 // SessionKey is returned, unless it is not generated
 // in which is returns an error. This function does
 // not check if it is verifies.
 func (p *Pake) SessionKey() ([]byte, error) {
-	var err error
 	if p == nil {
-		err = fmt.Errorf("pake is not initialized")
+		return nil, ErrNotInitialized
 	}
 	if p.K == nil {
-		err = errors.New("session key not generated")
+		return nil, ErrNoSessionKey
 	}
-	return p.K, err
+	return p.K, nil
 }
 
 // HaveSessionKey returns whether a session key has been generated
@@ -375,3 +894,25 @@ func (p *Pake) HaveSessionKey() bool {
 	}
 	return p.K != nil
 }
+
+// Export derives length bytes of keying material from the session key
+// for the given label and context, e.g. one encryption key per
+// direction, MAC keys or a channel ID. Different labels or contexts
+// give independent keys, and both parties derive the same keys after
+// Update.
+//
+// The key schedule is HKDF-SHA256: the exporter secret is extracted
+// from K with the hash of the transcript as salt, and each key is
+// expanded from it with the label and the context as info.
+func (p *Pake) Export(label string, context []byte, length int) ([]byte, error) {
+	if p == nil {
+		return nil, ErrNotInitialized
+	}
+	if p.K == nil {
+		return nil, ErrNoSessionKey
+	}
+	salt := sha256.Sum256(p.transcript)
+	secret := hkdfExtract(sha256.New, salt[:], p.K)
+	info := appendLengthPrefixed(nil, []byte("pake-v3 exporter"), []byte(label), context)
+	return hkdfExpand(sha256.New, secret, info, length)
+}
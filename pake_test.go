@@ -2,9 +2,17 @@ package pake
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	"strings"
 	"sync"
 	"testing"
+
+	"filippo.io/edwards25519"
 )
 
 func Example() {
@@ -43,8 +51,8 @@ func Example() {
 
 func TestBadCurve(t *testing.T) {
 	_, err := InitCurve([]byte{1, 2, 3}, 0, "bad")
-	if err == nil {
-		t.Errorf("curve should not exist!")
+	if !errors.Is(err, ErrUnknownCurve) {
+		t.Errorf("curve should not exist! got %v", err)
 	}
 }
 
@@ -85,21 +93,18 @@ func TestNilPakeOperations(t *testing.T) {
 
 	// Test Update on nil pake
 	err := p.Update([]byte("test"))
-	if err == nil {
-		t.Error("Update() should return error for nil pake")
+	if !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("Update() should return ErrNotInitialized for nil pake, got %v", err)
 	}
 }
 
 func TestNilPakeSessionKey(t *testing.T) {
 	var p *Pake
 
-	// Test SessionKey on nil pake - this currently panics due to accessing p.K
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("SessionKey() should panic for nil pake")
-		}
-	}()
-	p.SessionKey()
+	// Test SessionKey on nil pake
+	if _, err := p.SessionKey(); !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("SessionKey() should return ErrNotInitialized for nil pake, got %v", err)
+	}
 }
 
 func TestUpdateInvalidData(t *testing.T) {
@@ -109,28 +114,28 @@ func TestUpdateInvalidData(t *testing.T) {
 	}
 
 	tests := []struct {
-		name        string
-		data        []byte
-		expectPanic bool
+		name string
+		data []byte
+		want error
 	}{
-		{"empty data", []byte{}, false},
-		{"invalid json", []byte("invalid json"), false},
-		{"null data", []byte("null"), true},
-		{"malformed json", []byte("{invalid:}"), false},
+		{"empty data", []byte{}, ErrMalformedMessage},
+		{"invalid json", []byte("invalid json"), ErrMalformedMessage},
+		{"null data", []byte("null"), ErrMalformedMessage},
+		{"malformed json", []byte("{invalid:}"), ErrMalformedMessage},
+		{"invalid role", []byte(`{"Role":2}`), ErrMalformedMessage},
+		{"too large", make([]byte, 1<<20), ErrMalformedMessage},
+		{"missing coordinates", []byte(`{"Role":1}`), ErrInvalidPoint},
+		{"null coordinates", []byte(`{"Role":1,"Yᵤ":null,"Yᵥ":null}`), ErrInvalidPoint},
+		{"negative coordinates", []byte(`{"Role":1,"Yᵤ":-1,"Yᵥ":-1}`), ErrInvalidPoint},
+		{"oversized coordinates", []byte(`{"Role":1,"Yᵤ":1` + strings.Repeat("0", 200) + `,"Yᵥ":1}`), ErrInvalidPoint},
+		{"nil bytes", (*Pake)(nil).Bytes(), ErrMalformedMessage},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.expectPanic {
-				defer func() {
-					if r := recover(); r == nil {
-						t.Errorf("Update() should panic for %s", tt.name)
-					}
-				}()
-			}
 			err := A.Update(tt.data)
-			if !tt.expectPanic && err == nil {
-				t.Errorf("Update() should return error for %s", tt.name)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Update() should return %v for %s, got %v", tt.want, tt.name, err)
 			}
 		})
 	}
@@ -147,8 +152,8 @@ func TestSameRoleUpdate(t *testing.T) {
 	}
 
 	err = A1.Update(A2.Bytes())
-	if err == nil {
-		t.Error("Update() should return error when updating with same role")
+	if !errors.Is(err, ErrRoleConflict) {
+		t.Errorf("Update() should return ErrRoleConflict when updating with same role, got %v", err)
 	}
 }
 
@@ -159,8 +164,8 @@ func TestSessionKeyBeforeUpdate(t *testing.T) {
 	}
 
 	_, err = A.SessionKey()
-	if err == nil {
-		t.Error("SessionKey() should return error before Update()")
+	if !errors.Is(err, ErrNoSessionKey) {
+		t.Errorf("SessionKey() should return ErrNoSessionKey before Update(), got %v", err)
 	}
 
 	if A.HaveSessionKey() {
@@ -181,12 +186,15 @@ func TestMarshalingEdgeCases(t *testing.T) {
 
 	// Test marshaling uninitialized pake
 	var uninit *Pake
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("Bytes() should panic for uninitialized pake")
-		}
-	}()
-	uninit.Bytes()
+	if uninit.Bytes() != nil {
+		t.Error("Bytes() should return nil for uninitialized pake")
+	}
+	if uninit.Public() != nil {
+		t.Error("Public() should return nil for uninitialized pake")
+	}
+	if err := new(Pake).Update(bytes); !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("Update() should return ErrNotInitialized for a zero pake, got %v", err)
+	}
 }
 
 func TestConcurrentUsage(t *testing.T) {
@@ -409,3 +417,525 @@ func TestSessionKeyString(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyConfirmation(t *testing.T) {
+	for _, curve := range AvailableCurves() {
+		A, err := InitCurve([]byte{1, 2, 3}, 0, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err := InitCurve([]byte{1, 2, 3}, 1, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = A.Confirmation(); err == nil {
+			t.Errorf("Confirmation() should fail before Update() for %s", curve)
+		}
+		update(t, A, B)
+
+		cA, err := A.Confirmation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cB, err := B.Confirmation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(cA, cB) {
+			t.Errorf("confirmation tags of both roles should differ for %s", curve)
+		}
+		if err = B.VerifyConfirmation(cA); err != nil {
+			t.Errorf("B failed to verify A for %s: %v", curve, err)
+		}
+		if err = A.VerifyConfirmation(cB); err != nil {
+			t.Errorf("A failed to verify B for %s: %v", curve, err)
+		}
+		// a party must not accept its own tag reflected back
+		if err = A.VerifyConfirmation(cA); err != ErrConfirmationFailed {
+			t.Errorf("reflected tag should fail for %s, got %v", curve, err)
+		}
+	}
+}
+
+func TestKeyConfirmationWrongPassword(t *testing.T) {
+	for _, curve := range AvailableCurves() {
+		A, B := exchange(t, curve, []byte{1, 2, 3}, []byte{1, 2, 4}, Options{}, Options{})
+		cA, _ := A.Confirmation()
+		cB, _ := B.Confirmation()
+		if err := B.VerifyConfirmation(cA); err != ErrConfirmationFailed {
+			t.Errorf("expected ErrConfirmationFailed for %s, got %v", curve, err)
+		}
+		if err := A.VerifyConfirmation(cB); err != ErrConfirmationFailed {
+			t.Errorf("expected ErrConfirmationFailed for %s, got %v", curve, err)
+		}
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	tests := []struct {
+		name         string
+		optsA, optsB Options
+		agree        bool
+	}{
+		{"no identities", Options{}, Options{}, true},
+		{"matching identities",
+			Options{LocalIdentity: "alice", RemoteIdentity: "bob"},
+			Options{LocalIdentity: "bob", RemoteIdentity: "alice"}, true},
+		{"different peer",
+			Options{LocalIdentity: "alice", RemoteIdentity: "bob"},
+			Options{LocalIdentity: "carol", RemoteIdentity: "alice"}, false},
+		{"swapped roles",
+			Options{LocalIdentity: "alice", RemoteIdentity: "bob"},
+			Options{LocalIdentity: "alice", RemoteIdentity: "bob"}, false},
+		{"identities on one side only",
+			Options{LocalIdentity: "alice", RemoteIdentity: "bob"},
+			Options{}, false},
+		{"shifted identities",
+			Options{LocalIdentity: "alic", RemoteIdentity: "ebob"},
+			Options{LocalIdentity: "bob", RemoteIdentity: "alice"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			A, B := exchange(t, "p256", []byte{1, 2, 3}, []byte{1, 2, 3}, tt.optsA, tt.optsB)
+			kA, _ := A.SessionKey()
+			kB, _ := B.SessionKey()
+			if bytes.Equal(kA, kB) != tt.agree {
+				t.Errorf("keys equal = %v, want %v", !tt.agree, tt.agree)
+			}
+			cA, _ := A.Confirmation()
+			if err := B.VerifyConfirmation(cA); (err == nil) != tt.agree {
+				t.Errorf("VerifyConfirmation() error = %v, want agreement %v", err, tt.agree)
+			}
+		})
+	}
+
+	// without identities, the key must match the one of InitCurve
+	A, _ := InitCurve([]byte{1, 2, 3}, 0, "p256")
+	B, _ := InitCurveWithOptions([]byte{1, 2, 3}, 1, "p256", Options{})
+	B.Update(A.Bytes())
+	A.Update(B.Bytes())
+	kA, _ := A.SessionKey()
+	kB, _ := B.SessionKey()
+	if !bytes.Equal(kA, kB) {
+		t.Error("InitCurveWithOptions without identities should interoperate with InitCurve")
+	}
+}
+
+// ed25519TorsionPoints are the encodings of the eight points of small
+// order on Edwards25519, including the identity.
+var ed25519TorsionPoints = []string{
+	"0100000000000000000000000000000000000000000000000000000000000000",
+	"ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+	"0000000000000000000000000000000000000000000000000000000000000000",
+	"0000000000000000000000000000000000000000000000000000000000000080",
+	"26e8958fc2b227b045c3f489f2ef98f0d5dfac05d3c63339b13802886d53fc05",
+	"26e8958fc2b227b045c3f489f2ef98f0d5dfac05d3c63339b13802886d53fc85",
+	"c7176a703d4dd84fba3c0b760d10670f2a2053fa2c39ccc64ec7fd7792ac037a",
+	"c7176a703d4dd84fba3c0b760d10670f2a2053fa2c39ccc64ec7fd7792ac03fa",
+}
+
+// updateWithPoint sends p a message from the other role carrying the
+// point (x,y) as X or Y.
+func updateWithPoint(p *Pake, x, y *big.Int) error {
+	q := p.Public()
+	q.Role = 1 - p.Role
+	if q.Role == 0 {
+		q.Xᵤ, q.Xᵥ = x, y
+	} else {
+		q.Yᵤ, q.Yᵥ = x, y
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	return p.Update(b)
+}
+
+func TestSmallOrderPoints(t *testing.T) {
+	_, _, Ux, Uy, _, _, err := initCurve("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	U, _ := new(edwards25519.Point).SetBytes(ed25519PointFromBigInts(Ux, Uy))
+	for _, s := range ed25519TorsionPoints {
+		b, _ := hex.DecodeString(s)
+		T, err := new(edwards25519.Point).SetBytes(b)
+		if err != nil {
+			t.Fatalf("%s is not on the curve: %v", s, err)
+		}
+		if new(edwards25519.Point).MultByCofactor(T).Equal(edwards25519.NewIdentityPoint()) != 1 {
+			t.Fatalf("%s is not a torsion point", s)
+		}
+		points := [][]byte{b}
+		if T.Equal(edwards25519.NewIdentityPoint()) == 0 {
+			// U + T is on the curve but outside of the prime-order subgroup
+			points = append(points, new(edwards25519.Point).Add(U, T).Bytes())
+		}
+		for _, point := range points {
+			for role := 0; role < 2; role++ {
+				p, err := InitCurve([]byte{1, 2, 3}, role, "ed25519")
+				if err != nil {
+					t.Fatal(err)
+				}
+				x, y := ed25519PointToBigInts(point)
+				if err = updateWithPoint(p, x, y); err != ErrSmallOrderPoint {
+					t.Errorf("role %d accepted %x: %v", role, point, err)
+				}
+			}
+		}
+	}
+}
+
+func TestIdentityPoint(t *testing.T) {
+	for _, curve := range AvailableCurves() {
+		for role := 0; role < 2; role++ {
+			p, err := InitCurve([]byte{1, 2, 3}, role, curve)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = updateWithPoint(p, big.NewInt(0), big.NewInt(0)); err != ErrSmallOrderPoint {
+				t.Errorf("%s role %d accepted the point at infinity: %v", curve, role, err)
+			}
+		}
+	}
+}
+
+func TestCurveMismatch(t *testing.T) {
+	A, err := InitCurve([]byte{1, 2, 3}, 0, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgA, err := A.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, curve := range AvailableCurves() {
+		if curve == "p256" {
+			continue
+		}
+		B, err := InitCurve([]byte{1, 2, 3}, 1, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = B.Update(A.Bytes()); err != ErrCurveMismatch {
+			t.Errorf("%s accepted a p256 JSON message: %v", curve, err)
+		}
+		if err = B.Update(msgA); err != ErrCurveMismatch {
+			t.Errorf("%s accepted a p256 binary message: %v", curve, err)
+		}
+	}
+
+	B, err := InitCurve([]byte{1, 2, 3}, 1, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := A.Public()
+	q.Uᵤ, q.Uᵥ = q.Vᵤ, q.Vᵥ
+	b, err := json.Marshal(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = B.Update(b); err != ErrCurveMismatch {
+		t.Errorf("B accepted a message with a different U: %v", err)
+	}
+
+	// older peers do not send the curve name
+	q = A.Public()
+	q.Curve = ""
+	if b, err = json.Marshal(q); err != nil {
+		t.Fatal(err)
+	}
+	if err = B.Update(b); err != nil {
+		t.Errorf("B rejected a message without curve name: %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	for _, curve := range AvailableCurves() {
+		A, err := InitCurve([]byte{1, 2, 3}, 0, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err := InitCurve([]byte{1, 2, 3}, 1, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = A.Export("key", nil, 32); err == nil {
+			t.Error("Export should fail before the session key is generated")
+		}
+		update(t, A, B)
+
+		keyA, err := A.Export("client to server", nil, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyB, err := B.Export("client to server", nil, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(keyA, keyB) {
+			t.Errorf("exported keys not equal for %s", curve)
+		}
+		k, _ := A.SessionKey()
+		other, _ := A.Export("server to client", nil, 32)
+		withContext, _ := A.Export("client to server", []byte("context"), 32)
+		for _, b := range [][]byte{k, other, withContext} {
+			if bytes.Equal(keyA, b) {
+				t.Errorf("exported key for %s is not independent", curve)
+			}
+		}
+		long, err := A.Export("client to server", nil, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(long) != 100 || !bytes.Equal(long[:32], keyA) {
+			t.Errorf("unexpected exported key of length 100 for %s", curve)
+		}
+		if _, err = A.Export("too long", nil, 255*32+1); !errors.Is(err, ErrInvalidLength) {
+			t.Errorf("Export should fail for too long keys, got %v", err)
+		}
+	}
+	var p *Pake
+	if _, err := p.Export("key", nil, 32); err == nil {
+		t.Error("Export should fail on a nil pake")
+	}
+}
+
+// testReader is a deterministic source of randomness for known-answer
+// tests: the SHA-256 of the seed and a counter, in counter mode.
+type testReader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func newTestReader(seed string) *testReader {
+	return &testReader{seed: []byte(seed)}
+}
+
+func (r *testReader) Read(b []byte) (int, error) {
+	for len(r.buf) < len(b) {
+		block := sha256.Sum256(appendLengthPrefixed(nil, r.seed, big.NewInt(int64(r.counter)).Bytes()))
+		r.buf = append(r.buf, block[:]...)
+		r.counter++
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// deterministicExchange runs an exchange where A and B draw their
+// secrets from test readers with the given seeds.
+func deterministicExchange(t *testing.T, curve string, opts Options, seedA, seedB string) (A, B *Pake) {
+	t.Helper()
+	optsA, optsB := opts, opts
+	optsA.Rand, optsB.Rand = newTestReader(seedA), newTestReader(seedB)
+	return exchange(t, curve, []byte("password"), []byte("password"), optsA, optsB)
+}
+
+func TestDeterministicRand(t *testing.T) {
+	// the session keys of transcript version 1 for the password
+	// "password" and the seeds "A" and "B"
+	want := map[string]string{
+		"p521":         "e92d98f7ab239dc0bcfc24512c638a16c3b234ff7782eed6e10f52a914f1b050",
+		"p256":         "950e435b6a00db9ae3a909ec1c7e8d496b77d053bd7f7d1c566507f7b459629e",
+		"p384":         "ab3ac763f1e093e172b69d9d342682bc73c95e4233840932a6a365fdf18ee820",
+		"siec":         "26529665ed3749ec2e1ce17e4c194fc55ba49247d15e219a82834ca7e15f424a",
+		"ed25519":      "9aa7eb26ba0894e74436791f2d4662b8d553dbdd74e1be8267c06622b79731f7",
+		"ristretto255": "f83b05258be537e15ea128a1fd84f5968ac6f8a7780881ac89569a1047fa03c5",
+	}
+	for _, curve := range AvailableCurves() {
+		A, B := deterministicExchange(t, curve, Options{TranscriptVersion: 1}, "A", "B")
+		if got := hex.EncodeToString(A.K); got != want[curve] {
+			t.Errorf("%s: got session key %s, want %s", curve, got, want[curve])
+		}
+		if !bytes.Equal(A.K, B.K) {
+			t.Errorf("%s: session keys not equal", curve)
+		}
+		C, _ := deterministicExchange(t, curve, Options{TranscriptVersion: 1}, "A", "C")
+		if bytes.Equal(A.K, C.K) {
+			t.Errorf("%s: different seeds gave the same session key", curve)
+		}
+	}
+}
+
+func TestTranscriptVersion(t *testing.T) {
+	tests := []struct {
+		versionA, versionB int
+		want               int
+	}{
+		{0, 0, 1},
+		{1, 0, 1},
+		{0, 1, 1},
+		{2, 0, 2},
+		{2, 1, 1},
+		{2, 2, 2},
+	}
+	for _, curve := range AvailableCurves() {
+		for _, tt := range tests {
+			A, B := exchange(t, curve, []byte{1, 2, 3}, []byte{1, 2, 3},
+				Options{TranscriptVersion: tt.versionA}, Options{TranscriptVersion: tt.versionB})
+			if A.Version != tt.want || B.Version != tt.want {
+				t.Errorf("%s versions %d and %d: got %d and %d, want %d", curve, tt.versionA, tt.versionB, A.Version, B.Version, tt.want)
+			}
+			if !bytes.Equal(A.K, B.K) {
+				t.Errorf("%s versions %d and %d: session keys not equal", curve, tt.versionA, tt.versionB)
+			}
+		}
+
+		// the keys of the two versions differ
+		A1, _ := deterministicExchange(t, curve, Options{TranscriptVersion: 1}, "A", "B")
+		A2, _ := deterministicExchange(t, curve, Options{TranscriptVersion: 2}, "A", "B")
+		if bytes.Equal(A1.K, A2.K) {
+			t.Errorf("%s: transcript versions 1 and 2 gave the same session key", curve)
+		}
+	}
+
+	if _, err := InitCurveWithOptions([]byte{1, 2, 3}, 0, "p256", Options{TranscriptVersion: 3}); err == nil {
+		t.Error("unknown transcript version should fail")
+	}
+}
+
+func TestTranscriptVersionOldPeer(t *testing.T) {
+	exchange := func(curve string, optsA Options, editA, editB func(q *Pake)) (A, B *Pake) {
+		t.Helper()
+		A, err := InitCurveWithOptions([]byte{1, 2, 3}, 0, curve, optsA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err = InitCurve([]byte{1, 2, 3}, 1, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := A.Public()
+		editA(q)
+		msgA, _ := json.Marshal(q)
+		if err = B.Update(msgA); err != nil {
+			t.Fatal(err)
+		}
+		q = B.Public()
+		editB(q)
+		msgB, _ := json.Marshal(q)
+		if err = A.Update(msgB); err != nil {
+			t.Fatal(err)
+		}
+		return A, B
+	}
+	strip := func(q *Pake) { q.Version = 0 }
+
+	for _, curve := range AvailableCurves() {
+		// peers without the version field only know version 1, which
+		// is all that role 0 offers by default
+		A, B := exchange(curve, Options{}, strip, strip)
+		if A.Version != 1 || B.Version != 1 {
+			t.Errorf("%s: expected version 1, got %d and %d", curve, A.Version, B.Version)
+		}
+		if !bytes.Equal(A.K, B.K) {
+			t.Errorf("%s: session keys not equal", curve)
+		}
+
+		// rewriting or stripping the offer of role 0 is caught by the keys
+		for name, edit := range map[string]func(q *Pake){
+			"rewritten": func(q *Pake) { q.Version = 1 },
+			"stripped":  strip,
+		} {
+			A, B = exchange(curve, Options{TranscriptVersion: 2}, edit, strip)
+			if A.Version != 1 || B.Version != 1 {
+				t.Errorf("%s %s offer: expected version 1, got %d and %d", curve, name, A.Version, B.Version)
+			}
+			cB, _ := B.Confirmation()
+			if err := A.VerifyConfirmation(cB); err != ErrConfirmationFailed {
+				t.Errorf("%s %s offer: downgrade to version 1 was not detected, got %v", curve, name, err)
+			}
+		}
+	}
+
+	// role 1 cannot pick a version that role 0 did not offer
+	A, err := InitCurveWithOptions([]byte{1, 2, 3}, 0, "p256", Options{TranscriptVersion: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := InitCurve([]byte{1, 2, 3}, 1, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = B.Update(A.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	q := B.Public()
+	q.Version = 2
+	msgB, _ := json.Marshal(q)
+	if err = A.Update(msgB); err == nil {
+		t.Error("A accepted a transcript version it did not offer")
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	A, err := InitCurve([]byte{1, 2, 3}, 0, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := InitCurve([]byte{1, 2, 3}, 1, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offCurve := A.Public()
+	offCurve.Xᵥ = new(big.Int).Add(offCurve.Xᵥ, big.NewInt(1))
+	offCurveBytes, _ := json.Marshal(offCurve)
+	missing := A.Public()
+	missing.Xᵤ, missing.Xᵥ = nil, nil
+	missingBytes, _ := json.Marshal(missing)
+	_, errMode := InitCurveWithOptions([]byte{1, 2, 3}, 0, "p256", Options{Mode: Mode(42)})
+	_, errVersion := InitCurveWithOptions([]byte{1, 2, 3}, 0, "p256", Options{TranscriptVersion: 42})
+	_, errRFC9382 := InitCurveWithOptions([]byte{1, 2, 3}, 0, "siec", Options{Mode: ModeRFC9382})
+	_, errCurves := InitNegotiation([]byte{1, 2, 3}, 0, nil, Options{})
+	_, errVerifier := InitVerifier(&Verifier{Curve: "p256"}, Options{})
+	_, errNilVerifier := InitVerifier(nil, Options{})
+	_, errBinary := B.MarshalBinary()
+	_, errConfirmation := A.Confirmation()
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"point not on curve", B.Update(offCurveBytes), ErrInvalidPoint},
+		{"point missing", B.Update(missingBytes), ErrInvalidPoint},
+		{"binary message", B.Update([]byte{0x80}), ErrMalformedMessage},
+		{"unknown mode", errMode, ErrUnknownMode},
+		{"unknown transcript version", errVersion, ErrUnsupportedVersion},
+		{"curve not supported by mode", errRFC9382, ErrUnknownCurve},
+		{"no curves", errCurves, ErrInvalidCurves},
+		{"invalid verifier", errVerifier, ErrInvalidVerifier},
+		{"nil verifier", errNilVerifier, ErrNotInitialized},
+		{"binary before Update", errBinary, ErrNotInitialized},
+		{"confirmation before Update", errConfirmation, ErrNoSessionKey},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+}
+
+func TestNonCanonicalPoints(t *testing.T) {
+	for _, curve := range []string{"p256", "p384", "p521", "siec"} {
+		A, err := InitCurve([]byte{1, 2, 3}, 0, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		B, err := InitCurve([]byte{1, 2, 3}, 1, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the same point with y shifted by ±P
+		for _, y := range []*big.Int{
+			new(big.Int).Sub(A.Xᵥ, A.P),
+			new(big.Int).Add(A.Xᵥ, A.P),
+		} {
+			if err = updateWithPoint(B, A.Xᵤ, y); !errors.Is(err, ErrInvalidPoint) {
+				t.Errorf("%s accepted a non-canonical point: %v", curve, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package pake
+
+import (
+	"math/big"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2Params are the parameters of the Argon2id password stretching,
+// see Options.Argon2. Both parties must use the same parameters.
+type Argon2Params struct {
+	// Salt should be unique per password, e.g. derived from the
+	// identities of the parties or stored next to a Verifier.
+	Salt []byte
+
+	// Time, Memory (in KiB) and Threads default to the second
+	// recommended option of RFC 9106: 3 passes over 64 MiB with
+	// 4 lanes.
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// legacyPasswordDST is the domain separation tag of the password
+// scalar of the legacy mode with Options.HashPassword.
+var legacyPasswordDST = []byte("pake-v3-legacy-w")
+
+// stretchPassword returns pw after the Argon2id stretching selected by
+// opts, or pw itself when opts.Argon2 is nil.
+func stretchPassword(pw []byte, opts Options) []byte {
+	if opts.Argon2 == nil {
+		return pw
+	}
+	return opts.Argon2.key(pw, opts.Argon2.Salt, 64)
+}
+
+// key runs Argon2id over pw with the parameters of a, or their
+// defaults, and returns length bytes.
+func (a *Argon2Params) key(pw, salt []byte, length uint32) []byte {
+	time, memory, threads := a.Time, a.Memory, a.Threads
+	if time == 0 {
+		time = 3
+	}
+	if memory == 0 {
+		memory = 64 * 1024
+	}
+	if threads == 0 {
+		threads = 4
+	}
+	return argon2.IDKey(pw, salt, time, memory, threads, length)
+}
+
+// hashesPassword reports whether the legacy mode derives its password
+// scalar with initPasswordHash.
+func (o Options) hashesPassword() bool {
+	return o.HashPassword || o.Argon2 != nil
+}
+
+// initPasswordHash derives the password scalar w of the legacy mode by
+// hashing the (stretched) password to an integer modulo the group
+// order, so that every byte of the password counts on every curve.
+func (p *Pake) initPasswordHash(pw []byte) (err error) {
+	p.w, err = hashToScalar(p.hash, p.order, pw, legacyPasswordDST)
+	return
+}
+
+// passwordScalar is the password scalar of the legacy mode without
+// Options.HashPassword: the password read as a big-endian integer of
+// any length, reduced modulo the group order. This is what the
+// Weierstrass curves and ristretto255 always computed, and every byte
+// of the password counts on every curve.
+func (p *Pake) passwordScalar() []byte {
+	w := new(big.Int).SetBytes(p.Pw)
+	return w.Mod(w, p.order).Bytes()
+}
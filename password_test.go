@@ -0,0 +1,133 @@
+package pake
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// cheapArgon2 keeps the tests fast.
+var cheapArgon2 = &Argon2Params{Salt: []byte("salt"), Time: 1, Memory: 64, Threads: 1}
+
+// passwordMultiple returns the password multiple of U computed by a
+// role 0 with the given password and options.
+func passwordMultiple(t *testing.T, curve string, pw []byte, opts Options) (x, y *big.Int) {
+	t.Helper()
+	A, err := InitCurveWithOptions(pw, 0, curve, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return A.Upwᵤ, A.Upwᵥ
+}
+
+func TestPasswordEncoding(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 1000)
+	longer := append(bytes.Repeat([]byte("a"), 999), 'b')
+	prefix := append(bytes.Repeat([]byte("a"), 32), 'b')
+	v2 := Options{TranscriptVersion: 2}
+	for _, curve := range AvailableCurves() {
+		x, y := passwordMultiple(t, curve, long, v2)
+		for _, pw := range [][]byte{longer, prefix} {
+			if x2, y2 := passwordMultiple(t, curve, pw, v2); equalPoint(x, y, x2, y2) {
+				t.Errorf("%s: passwords differing after the first 32 bytes have the same multiple of U", curve)
+			}
+		}
+		// an offer of version 1 keeps the original encoding, which
+		// only uses the first 32 bytes of the password on ed25519
+		x1, y1 := passwordMultiple(t, curve, long, Options{})
+		x2, y2 := passwordMultiple(t, curve, prefix, Options{})
+		if truncated := equalPoint(x1, y1, x2, y2); truncated != (curve == "ed25519") {
+			t.Errorf("%s: original encoding truncated the password: %v", curve, truncated)
+		}
+
+		if !agreed(exchange(t, curve, long, long, v2, Options{})) {
+			t.Errorf("%s: same long passwords did not agree", curve)
+		}
+		// the encoding follows the offer of role 0: an offer of
+		// version 1 keeps the original encoding on both sides, and
+		// role 1 limited to version 1 uses the encoding of the offer
+		if !agreed(exchange(t, curve, long, long, Options{}, Options{})) {
+			t.Errorf("%s: transcript version 1 offered by role 0 did not agree", curve)
+		}
+		if !agreed(exchange(t, curve, long, long, v2, Options{TranscriptVersion: 1})) {
+			t.Errorf("%s: transcript version 1 chosen by role 1 did not agree", curve)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 1000)
+	longer := append(bytes.Repeat([]byte("a"), 999), 'b')
+	for _, curve := range AvailableCurves() {
+		for _, opts := range []Options{{HashPassword: true}, {Argon2: cheapArgon2}} {
+			if !agreed(exchange(t, curve, []byte("password"), []byte("password"), opts, opts)) {
+				t.Errorf("%s: same passwords did not agree", curve)
+			}
+			x1, y1 := passwordMultiple(t, curve, long, opts)
+			x2, y2 := passwordMultiple(t, curve, longer, opts)
+			if equalPoint(x1, y1, x2, y2) {
+				t.Errorf("%s: passwords differing after the first 32 bytes have the same multiple of U", curve)
+			}
+		}
+		if agreed(exchange(t, curve, []byte("password"), []byte("password"), Options{}, Options{HashPassword: true})) {
+			t.Errorf("%s: hashed and raw password agreed", curve)
+		}
+	}
+}
+
+func TestArgon2(t *testing.T) {
+	otherSalt := *cheapArgon2
+	otherSalt.Salt = []byte("pepper")
+	for _, mode := range []Mode{ModeLegacy, ModeRFC9382, ModeSPAKE2Plus, ModeCPace} {
+		opts := Options{Mode: mode, Argon2: cheapArgon2}
+		if !agreed(exchange(t, modeCurve(mode), []byte("password"), []byte("password"), opts, opts)) {
+			t.Errorf("mode %d: same passwords did not agree", mode)
+		}
+		if agreed(exchange(t, modeCurve(mode), []byte("password"), []byte("password"), opts, Options{Mode: mode, Argon2: &otherSalt})) {
+			t.Errorf("mode %d: different salts agreed", mode)
+		}
+		if agreed(exchange(t, modeCurve(mode), []byte("password"), []byte("password"), opts, Options{Mode: mode})) {
+			t.Errorf("mode %d: stretched and unstretched password agreed", mode)
+		}
+	}
+}
+
+func TestArgon2Verifier(t *testing.T) {
+	opts := Options{Argon2: cheapArgon2, LocalIdentity: "client", RemoteIdentity: "server"}
+	v, err := NewVerifier([]byte("password"), "p256", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	A, err := InitCurveWithOptions([]byte("password"), 0, "p256", Options{
+		Mode: ModeSPAKE2Plus, Argon2: cheapArgon2, LocalIdentity: "client", RemoteIdentity: "server",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := InitVerifier(v, Options{LocalIdentity: "server", RemoteIdentity: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update(t, A, B)
+	cA, _ := A.Confirmation()
+	if err = B.VerifyConfirmation(cA); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestArgon2Negotiation(t *testing.T) {
+	opts := Options{Argon2: cheapArgon2}
+	A, err := InitNegotiation([]byte("password"), 0, []string{"ed25519", "p256"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := InitNegotiation([]byte("password"), 1, []string{"p256"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	negotiate(t, A, B, A.Bytes())
+	cA, _ := A.Confirmation()
+	if err = B.VerifyConfirmation(cA); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,113 @@
+package pake
+
+import (
+	"crypto/elliptic"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+// rfc9382Points holds the M and N points of RFC 9382, section 6,
+// in compressed SEC1 encoding for the NIST curves and in the standard
+// point encoding for Edwards25519. They are generated from the seeds
+// "<curve OID> point generation seed (M)" and "... (N)".
+var rfc9382Points = map[string][2]string{
+	"p256": {
+		"02886e2f97ace46e55ba9dd7242579f2993b64e16ef3dcab95afd497333d8fa12f",
+		"03d8bbd6c639c62937b04d997f38c3770719c629d7014d49a24b4f98baa1292b49",
+	},
+	"p384": {
+		"030ff0895ae5ebf6187080a82d82b42e2765e3b2f8749c7e05eba366434b363d3dc36f15314739074d2eb8613fceec2853",
+		"02c72cf2e390853a1c1c4ad816a62fd15824f56078918f43f922ca21518f9c543bb252c5490214cf9aa3f0baab4b665c10",
+	},
+	"p521": {
+		"02003f06f38131b2ba2600791e82488e8d20ab889af753a41806c5db18d37d85608cfae06b82e4a72cd744c719193562a653ea1f119eef9356907edc9b56979962d7aa",
+		"0200c7924b9ec017f3094562894336a53c50167ba8c5963876880542bc669e494b2532d76c5b53dfb349fdf69154b9e0048c58a42e8ed04cef052a3bc349d95575cd25",
+	},
+	"ed25519": {
+		"d048032c6ea0b6d697ddc2e86bda85a33adac920f1bf18e1b0c6d166a5cecdaf",
+		"d3bfb518f44f3430f29d0c92af503865a1ed3281dc69b35dd868ba85f886c4ab",
+	},
+}
+
+// rfc9382DST is the domain separation tag used to hash the password
+// to the scalar w.
+var rfc9382DST = []byte("pake-v3-SPAKE2-RFC9382-w")
+
+// decodeRFC9382Point decodes one of the points in rfc9382Points.
+func decodeRFC9382Point(curve EllipticCurve, s string) (x, y *big.Int, err error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return
+	}
+	if c, ok := curve.(elliptic.Curve); ok {
+		x, y = elliptic.UnmarshalCompressed(c, b)
+	} else {
+		x, y = ed25519PointToBigInts(b)
+	}
+	if x == nil || !curve.IsOnCurve(x, y) {
+		err = fmt.Errorf("%w: RFC 9382 point %s not on curve", ErrInvalidPoint, s)
+	}
+	return
+}
+
+// initRFC9382 replaces U and V with the M and N points of RFC 9382
+// and derives the password scalar w from the (stretched) password.
+func (p *Pake) initRFC9382(curve string, pw []byte) (err error) {
+	if err = p.useRFC9382Points(curve); err != nil {
+		return
+	}
+	// w = MHF(pw) mod p
+	p.w, err = hashToScalar(p.hash, p.order, pw, rfc9382DST)
+	return
+}
+
+// useRFC9382Points replaces U and V with the M and N points of
+// RFC 9382 and selects the hash function of the ciphersuite.
+func (p *Pake) useRFC9382Points(curve string) (err error) {
+	points, ok := rfc9382Points[curve]
+	if !ok {
+		return fmt.Errorf("%w: %q is not supported by RFC 9382", ErrUnknownCurve, curve)
+	}
+	p.Uᵤ, p.Uᵥ, err = decodeRFC9382Point(p.curve, points[0])
+	if err != nil {
+		return
+	}
+	p.Vᵤ, p.Vᵥ, err = decodeRFC9382Point(p.curve, points[1])
+	if err != nil {
+		return
+	}
+	if curve == "p521" {
+		p.hash = sha512.New
+	}
+	return
+}
+
+// deriveRFC9382Keys computes the transcript TT and derives Ke, which
+// becomes the session key, and the confirmation keys KcA and KcB.
+func (p *Pake) deriveRFC9382Keys() error {
+	// TT = len(A) || A || len(B) || B || len(pA) || pA || len(pB) || pB
+	//      || len(K) || K || len(w) || w
+	p.transcript = appendLengthPrefixed(nil,
+		p.idA, p.idB,
+		p.encodePoint(p.Xᵤ, p.Xᵥ),
+		p.encodePoint(p.Yᵤ, p.Yᵥ),
+		p.encodePoint(p.Zᵤ, p.Zᵥ),
+		p.w,
+	)
+	// Ke || Ka = Hash(TT)
+	H := p.hash()
+	H.Write(p.transcript)
+	sum := H.Sum(nil)
+	half := len(sum) / 2
+	// KcA || KcB = KDF(Ka, nil, "ConfirmationKeys" || AAD)
+	info := append([]byte("ConfirmationKeys"), p.aad...)
+	kc, err := hkdf(p.hash, sum[half:], nil, info, len(sum))
+	if err != nil {
+		return err
+	}
+	p.kcA, p.kcB = kc[:half], kc[half:]
+	p.K = sum[:half]
+	return nil
+}
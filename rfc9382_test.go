@@ -0,0 +1,210 @@
+package pake
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/edwards25519"
+)
+
+var rfc9382Curves = []string{"p256", "p384", "p521", "ed25519"}
+
+func TestRFC9382(t *testing.T) {
+	for _, curve := range rfc9382Curves {
+		A, B := exchange(t, curve, []byte("password"), []byte("password"),
+			Options{Mode: ModeRFC9382, LocalIdentity: "server", RemoteIdentity: "client"},
+			Options{Mode: ModeRFC9382, LocalIdentity: "client", RemoteIdentity: "server"},
+		)
+		kA, _ := A.SessionKey()
+		kB, _ := B.SessionKey()
+		if !bytes.Equal(kA, kB) {
+			t.Errorf("session keys not equal for %s", curve)
+		}
+		if len(kA) != A.hash().Size()/2 {
+			t.Errorf("Ke should be half of the hash output for %s, got %d bytes", curve, len(kA))
+		}
+		cA, _ := A.Confirmation()
+		cB, _ := B.Confirmation()
+		if err := B.VerifyConfirmation(cA); err != nil {
+			t.Errorf("B failed to verify A for %s: %v", curve, err)
+		}
+		if err := A.VerifyConfirmation(cB); err != nil {
+			t.Errorf("A failed to verify B for %s: %v", curve, err)
+		}
+	}
+}
+
+func TestRFC9382Mismatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		pwA, pwB     []byte
+		optsA, optsB Options
+	}{
+		{"password", []byte("password"), []byte("passw0rd"), Options{}, Options{}},
+		{"identity", []byte("password"), []byte("password"),
+			Options{LocalIdentity: "alice", RemoteIdentity: "bob"},
+			Options{LocalIdentity: "bob", RemoteIdentity: "mallory"}},
+	}
+	for _, curve := range rfc9382Curves {
+		for _, tt := range tests {
+			tt.optsA.Mode, tt.optsB.Mode = ModeRFC9382, ModeRFC9382
+			A, B := exchange(t, curve, tt.pwA, tt.pwB, tt.optsA, tt.optsB)
+			kA, _ := A.SessionKey()
+			kB, _ := B.SessionKey()
+			if bytes.Equal(kA, kB) {
+				t.Errorf("%s mismatch should produce different keys for %s", tt.name, curve)
+			}
+			cA, _ := A.Confirmation()
+			if err := B.VerifyConfirmation(cA); err != ErrConfirmationFailed {
+				t.Errorf("%s mismatch should fail confirmation for %s, got %v", tt.name, curve, err)
+			}
+		}
+	}
+}
+
+func TestRFC9382Vectors(t *testing.T) {
+	// RFC 9382, appendix B
+	var vectors []struct {
+		A, B, W, X, PA, Y, PB, K, TT string
+		Ke, Ka, KcA, KcB, CA, CB     string
+	}
+	readJSON(t, filepath.Join("testdata", "rfc9382", "SPAKE2-P256-SHA256-HKDF-HMAC.json"), &vectors)
+	for i, v := range vectors {
+		// the vectors give w instead of the password, and the secrets
+		// are picked through Options.Rand
+		A, err := InitCurveWithOptions([]byte("password"), 0, "p256", Options{
+			Mode: ModeRFC9382, LocalIdentity: v.A, RemoteIdentity: v.B,
+			Rand: vectorReader(ModeRFC9382, mustHex(t, v.X)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		A.w = mustHex(t, v.W)
+		A.Upwᵤ, A.Upwᵥ = A.multiplyPassword(A.Uᵤ, A.Uᵥ)
+		A.Vpwᵤ, A.Vpwᵥ = A.multiplyPassword(A.Vᵤ, A.Vᵥ)
+		A.Xᵤ, A.Xᵥ = A.curve.Add(A.Upwᵤ, A.Upwᵥ, A.Aαᵤ, A.Aαᵥ)
+		B, err := InitCurveWithOptions([]byte("password"), 1, "p256", Options{
+			Mode: ModeRFC9382, LocalIdentity: v.B, RemoteIdentity: v.A,
+			Rand: vectorReader(ModeRFC9382, mustHex(t, v.Y)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		B.w = mustHex(t, v.W)
+		update(t, A, B)
+		sum := sha256.Sum256(A.transcript)
+		cA, _ := A.Confirmation()
+		cB, _ := B.Confirmation()
+		for _, c := range []struct {
+			name      string
+			got, want string
+		}{
+			{"pA", hex.EncodeToString(B.encodePoint(B.Xᵤ, B.Xᵥ)), v.PA},
+			{"pB", hex.EncodeToString(A.encodePoint(A.Yᵤ, A.Yᵥ)), v.PB},
+			{"K of A", hex.EncodeToString(A.encodePoint(A.Zᵤ, A.Zᵥ)), v.K},
+			{"K of B", hex.EncodeToString(B.encodePoint(B.Zᵤ, B.Zᵥ)), v.K},
+			{"TT", hex.EncodeToString(B.transcript), v.TT},
+			{"Ke", hex.EncodeToString(A.K), v.Ke},
+			{"Ka", hex.EncodeToString(sum[16:]), v.Ka},
+			{"KcA", hex.EncodeToString(B.kcA), v.KcA},
+			{"KcB", hex.EncodeToString(A.kcB), v.KcB},
+			{"A conf", hex.EncodeToString(cA), v.CA},
+			{"B conf", hex.EncodeToString(cB), v.CB},
+		} {
+			if c.got != c.want {
+				t.Errorf("vector %d: %s is %s, want %s", i, c.name, c.got, c.want)
+			}
+		}
+	}
+}
+
+func TestRFC9382DiffersFromLegacy(t *testing.T) {
+	A, err := InitCurveWithOptions([]byte{1, 2, 3}, 0, "p256", Options{Mode: ModeRFC9382})
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := InitCurve([]byte{1, 2, 3}, 1, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = B.Update(A.Bytes()); !errors.Is(err, ErrModeMismatch) {
+		t.Errorf("mixing modes should be a mode mismatch, got %v", err)
+	}
+	msgA, err := A.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = B.Update(msgA); !errors.Is(err, ErrModeMismatch) {
+		t.Errorf("mixing modes should be a mode mismatch, got %v", err)
+	}
+}
+
+func TestRFC9382UnsupportedCurve(t *testing.T) {
+	if _, err := InitCurveWithOptions([]byte{1, 2, 3}, 0, "siec", Options{Mode: ModeRFC9382}); err == nil {
+		t.Error("siec should not be supported by RFC 9382")
+	}
+	if _, err := InitCurveWithOptions([]byte{1, 2, 3}, 0, "p256", Options{Mode: Mode(42)}); err == nil {
+		t.Error("unknown mode should fail")
+	}
+}
+
+// TestRFC9382Points regenerates M and N with the algorithm of
+// RFC 9382, appendix A.
+func TestRFC9382Points(t *testing.T) {
+	seeds := map[string]string{
+		"p256":    "1.2.840.10045.3.1.7",
+		"p384":    "1.3.132.0.34",
+		"p521":    "1.3.132.0.35",
+		"ed25519": "edwards25519",
+	}
+	iteratedHash := func(seed []byte, n int) []byte {
+		h := seed
+		for i := 0; i < n; i++ {
+			sum := sha256.Sum256(h)
+			h = sum[:]
+		}
+		return h
+	}
+	for curve, points := range rfc9382Points {
+		for i, name := range []string{"M", "N"} {
+			seed := []byte(seeds[curve] + " point generation seed (" + name + ")")
+			var found string
+			for j := 1; j < 1000 && found == ""; j++ {
+				if curve == "ed25519" {
+					b := iteratedHash(seed, j)
+					P, err := new(edwards25519.Point).SetBytes(b)
+					if err != nil {
+						continue
+					}
+					// P must be of order ℓ: ℓ·P = (ℓ-1)·P + P = O
+					lm1 := new(edwards25519.Scalar).Subtract(edwards25519.NewScalar(), ed25519Scalar([]byte{1}))
+					Q := new(edwards25519.Point).ScalarMult(lm1, P)
+					Q.Add(Q, P)
+					if P.Equal(edwards25519.NewIdentityPoint()) == 0 && Q.Equal(edwards25519.NewIdentityPoint()) == 1 {
+						found = hex.EncodeToString(b)
+					}
+					continue
+				}
+				c := map[string]elliptic.Curve{"p256": elliptic.P256(), "p384": elliptic.P384(), "p521": elliptic.P521()}[curve]
+				size := (c.Params().BitSize+7)/8 + 1
+				var b []byte
+				for k := j; len(b) < size; k++ {
+					b = append(b, iteratedHash(seed, k)...)
+				}
+				b = b[:size]
+				b[0] = b[0]&1 | 2
+				if x, _ := elliptic.UnmarshalCompressed(c, b); x != nil {
+					found = hex.EncodeToString(b)
+				}
+			}
+			if found != points[i] {
+				t.Errorf("%s point %s = %s, want %s", curve, name, points[i], found)
+			}
+		}
+	}
+}
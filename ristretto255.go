@@ -0,0 +1,248 @@
+package pake
+
+import (
+	"fmt"
+	"math/big"
+
+	"filippo.io/edwards25519"
+	"filippo.io/edwards25519/field"
+)
+
+// Ristretto255Curve implements EllipticCurve interface for the
+// prime-order ristretto255 group (RFC 9496) built on Edwards25519.
+// Like Edwards25519Curve, it stores the 32-byte encoding of an element
+// in the x coordinate and y is always zero. The identity element is
+// encoded as all zeros, i.e. (0, 0).
+//
+// Scalars are big-endian integers reduced modulo the group order, so
+// they are neither truncated nor clamped.
+type Ristretto255Curve struct{}
+
+func (r *Ristretto255Curve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	p1, err1 := ristrettoDecode(ed25519PointFromBigInts(x1, y1))
+	p2, err2 := ristrettoDecode(ed25519PointFromBigInts(x2, y2))
+	if err1 != nil || err2 != nil {
+		return big.NewInt(0), big.NewInt(0)
+	}
+	result := (&edwards25519.Point{}).Add(p1, p2)
+	return ed25519PointToBigInts(ristrettoEncode(result))
+}
+
+// Subtract performs element subtraction for ristretto255
+func (r *Ristretto255Curve) Subtract(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	p1, err1 := ristrettoDecode(ed25519PointFromBigInts(x1, y1))
+	p2, err2 := ristrettoDecode(ed25519PointFromBigInts(x2, y2))
+	if err1 != nil || err2 != nil {
+		return big.NewInt(0), big.NewInt(0)
+	}
+	result := (&edwards25519.Point{}).Subtract(p1, p2)
+	return ed25519PointToBigInts(ristrettoEncode(result))
+}
+
+func (r *Ristretto255Curve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	result := (&edwards25519.Point{}).ScalarBaseMult(ed25519Scalar(k))
+	return ed25519PointToBigInts(ristrettoEncode(result))
+}
+
+func (r *Ristretto255Curve) ScalarMult(Bx, By *big.Int, k []byte) (*big.Int, *big.Int) {
+	point, err := ristrettoDecode(ed25519PointFromBigInts(Bx, By))
+	if err != nil {
+		return big.NewInt(0), big.NewInt(0)
+	}
+	result := (&edwards25519.Point{}).ScalarMult(ed25519Scalar(k), point)
+	return ed25519PointToBigInts(ristrettoEncode(result))
+}
+
+// IsOnCurve reports whether x holds a canonical ristretto255 encoding.
+func (r *Ristretto255Curve) IsOnCurve(x, y *big.Int) bool {
+	if x.Sign() < 0 || x.BitLen() > 256 || y.Sign() != 0 {
+		return false
+	}
+	_, err := ristrettoDecode(ed25519PointFromBigInts(x, y))
+	return err == nil
+}
+
+// ristretto255 constants from RFC 9496, section 4.1, computed from d.
+var (
+	ristrettoD             = mustFieldElement("37095705934669439343138083508754565189542113879843219016388785533085940283555")
+	ristrettoSqrtM1        = mustFieldElement("19681161376707505956807079304988542015446066515923890162744021073123829784752")
+	ristrettoInvSqrtAMinD  = mustFieldElement("54469307008909316920995813868745141605393597292927456921205312896311721017578")
+	ristrettoSqrtADMinOne  = mustFieldElement("25063068953384623474111414158702152701244531502492656460079210482610430750235")
+	ristrettoOneMinusDSq   = mustFieldElement("1159843021668779879193775521855586647937357759715417654439879720876111806838")
+	ristrettoDMinusOneSq   = mustFieldElement("40440834346308536858101042469323190826248399146238708352240133220865137265952")
+	errInvalidRistrettoEnc = fmt.Errorf("%w: invalid ristretto255 encoding", ErrInvalidPoint)
+)
+
+// mustFieldElement parses a decimal integer into a field element.
+func mustFieldElement(s string) *field.Element {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid field element " + s)
+	}
+	b := n.FillBytes(make([]byte, 32))
+	reverseBytes(b)
+	e, err := new(field.Element).SetBytes(b)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// reverseBytes reverses b in place.
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// ristrettoDecode decodes a ristretto255 element as specified in
+// RFC 9496, section 4.3.1.
+func ristrettoDecode(b []byte) (*edwards25519.Point, error) {
+	if len(b) != 32 {
+		return nil, errInvalidRistrettoEnc
+	}
+	s, err := new(field.Element).SetBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	// reject non-canonical and negative encodings of s
+	if !bytesEqual(s.Bytes(), b) || s.IsNegative() == 1 {
+		return nil, errInvalidRistrettoEnc
+	}
+
+	one := new(field.Element).One()
+	ss := new(field.Element).Square(s)
+	u1 := new(field.Element).Subtract(one, ss)
+	u2 := new(field.Element).Add(one, ss)
+	u2Sqr := new(field.Element).Square(u2)
+
+	// v = -(D * u1^2) - u2_sqr
+	v := new(field.Element).Square(u1)
+	v.Multiply(v, ristrettoD)
+	v.Negate(v)
+	v.Subtract(v, u2Sqr)
+
+	invSqrt, wasSquare := new(field.Element).SqrtRatio(one, new(field.Element).Multiply(v, u2Sqr))
+
+	denX := new(field.Element).Multiply(invSqrt, u2)
+	denY := new(field.Element).Multiply(invSqrt, denX)
+	denY.Multiply(denY, v)
+
+	x := new(field.Element).Multiply(s, denX)
+	x.Add(x, x)
+	x.Absolute(x)
+	y := new(field.Element).Multiply(u1, denY)
+	t := new(field.Element).Multiply(x, y)
+
+	if wasSquare == 0 || t.IsNegative() == 1 || y.Equal(new(field.Element).Zero()) == 1 {
+		return nil, errInvalidRistrettoEnc
+	}
+	return new(edwards25519.Point).SetExtendedCoordinates(x, y, one, t)
+}
+
+// ristrettoEncode encodes the ristretto255 element represented by p
+// as specified in RFC 9496, section 4.3.2.
+func ristrettoEncode(p *edwards25519.Point) []byte {
+	x0, y0, z0, t0 := p.ExtendedCoordinates()
+
+	u1 := new(field.Element).Add(z0, y0)
+	u1.Multiply(u1, new(field.Element).Subtract(z0, y0))
+	u2 := new(field.Element).Multiply(x0, y0)
+
+	// Ignore was_square since this is always square.
+	invSqrt, _ := new(field.Element).SqrtRatio(new(field.Element).One(),
+		new(field.Element).Multiply(u1, new(field.Element).Square(u2)))
+
+	den1 := new(field.Element).Multiply(invSqrt, u1)
+	den2 := new(field.Element).Multiply(invSqrt, u2)
+	zInv := new(field.Element).Multiply(den1, den2)
+	zInv.Multiply(zInv, t0)
+
+	ix0 := new(field.Element).Multiply(x0, ristrettoSqrtM1)
+	iy0 := new(field.Element).Multiply(y0, ristrettoSqrtM1)
+	enchantedDenominator := new(field.Element).Multiply(den1, ristrettoInvSqrtAMinD)
+
+	rotate := new(field.Element).Multiply(t0, zInv).IsNegative()
+
+	x := new(field.Element).Select(iy0, x0, rotate)
+	y := new(field.Element).Select(ix0, y0, rotate)
+	denInv := new(field.Element).Select(enchantedDenominator, den2, rotate)
+
+	yNeg := new(field.Element).Negate(y)
+	y.Select(yNeg, y, new(field.Element).Multiply(x, zInv).IsNegative())
+
+	s := new(field.Element).Subtract(z0, y)
+	s.Multiply(s, denInv)
+	s.Absolute(s)
+	return s.Bytes()
+}
+
+// ristrettoFromUniformBytes maps 64 uniformly random bytes to a
+// ristretto255 element with the one-way map of RFC 9496, section 4.3.4.
+func ristrettoFromUniformBytes(b []byte) *edwards25519.Point {
+	p1 := ristrettoMap(b[:32])
+	p2 := ristrettoMap(b[32:64])
+	return p1.Add(p1, p2)
+}
+
+// ristrettoMap is the MAP function of RFC 9496, section 4.3.4.
+func ristrettoMap(b []byte) *edwards25519.Point {
+	t, _ := new(field.Element).SetBytes(b) // ignores the top bit
+	one := new(field.Element).One()
+	minusOne := new(field.Element).Negate(one)
+
+	// r = SQRT_M1 * t^2
+	r := new(field.Element).Square(t)
+	r.Multiply(r, ristrettoSqrtM1)
+
+	// u = (r + 1) * ONE_MINUS_D_SQ
+	u := new(field.Element).Add(r, one)
+	u.Multiply(u, ristrettoOneMinusDSq)
+
+	// v = (-1 - r*D) * (r + D)
+	v := new(field.Element).Multiply(r, ristrettoD)
+	v.Subtract(minusOne, v)
+	v.Multiply(v, new(field.Element).Add(r, ristrettoD))
+
+	s, wasSquare := new(field.Element).SqrtRatio(u, v)
+	sPrime := new(field.Element).Multiply(s, t)
+	sPrime.Absolute(sPrime)
+	sPrime.Negate(sPrime)
+	s.Select(s, sPrime, wasSquare)
+	c := new(field.Element).Select(minusOne, r, wasSquare)
+
+	// N = c * (r - 1) * D_MINUS_ONE_SQ - v
+	N := new(field.Element).Subtract(r, one)
+	N.Multiply(N, c)
+	N.Multiply(N, ristrettoDMinusOneSq)
+	N.Subtract(N, v)
+
+	sSq := new(field.Element).Square(s)
+	w0 := new(field.Element).Multiply(s, v)
+	w0.Add(w0, w0)
+	w1 := new(field.Element).Multiply(N, ristrettoSqrtADMinOne)
+	w2 := new(field.Element).Subtract(one, sSq)
+	w3 := new(field.Element).Add(one, sSq)
+
+	X := new(field.Element).Multiply(w0, w3)
+	Y := new(field.Element).Multiply(w2, w1)
+	Z := new(field.Element).Multiply(w1, w3)
+	T := new(field.Element).Multiply(w0, w2)
+	p, err := new(edwards25519.Point).SetExtendedCoordinates(X, Y, Z, T)
+	if err != nil {
+		panic("ristretto255: map produced an invalid point")
+	}
+	return p
+}
+
+// bytesEqual compares two byte slices in constant time.
+func bytesEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	var v byte
+	for i := range a {
+		v |= a[i] ^ b[i]
+	}
+	return v == 0
+}
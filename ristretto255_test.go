@@ -0,0 +1,109 @@
+package pake
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"filippo.io/edwards25519"
+)
+
+// multiples of the generator from RFC 9496, appendix A.1
+var ristrettoGeneratorMultiples = []string{
+	"0000000000000000000000000000000000000000000000000000000000000000",
+	"e2f2ae0a6abc4e71a884a961c500515f58e30b6aa582dd8db6a65945e08d2d76",
+	"6a493210f7499cd17fecb510ae0cea23a110e8d5b901f8acadd3095c73a3b919",
+	"94741f5d5d52755ece4f23f044ee27d5d1ea1e2bd196b462166b16152a9d0259",
+	"da80862773358b466ffadfe0b3293ab3d9fd53c5ea6c955358f568322daf6a57",
+	"e882b131016b52c1d3337080187cf768423efccbb517bb495ab812c4160ff44e",
+	"f64746d3c92b13050ed8d80236a7f0007c3b3f962f5ba793d19a601ebb1df403",
+	"44f53520926ec81fbd5a387845beb7df85a96a24ece18738bdcfa6a7822a176d",
+	"903293d8f2287ebe10e2374dc1a53e0bc887e592699f02d077d5263cdd55601c",
+	"02622ace8f7303a31cafc63f8fc48fdc16e1c8c8d234b2f0d6685282a9076031",
+	"20706fd788b2720a1ed2a5dad4952b01f413bcf0e7564de8cdc816689e2db95f",
+	"bce83f8ba5dd2fa572864c24ba1810f9522bc6004afe95877ac73241cafdab42",
+	"e4549ee16b9aa03099ca208c67adafcafa4c3f3e4e5303de6026e3ca8ff84460",
+	"aa52e000df2e16f55fb1032fc33bc42742dad6bd5a8fc0be0167436c5948501f",
+	"46376b80f409b29dc2b5f6f0c52591990896e5716f41477cd30085ab7f10301e",
+	"e0c418f7c8d9c4cdd7395b93ea124f3ad99021bb681dfc3302a9d99a2e53e64e",
+}
+
+func TestRistrettoGeneratorMultiples(t *testing.T) {
+	curve := &Ristretto255Curve{}
+	P := edwards25519.NewIdentityPoint()
+	for i, want := range ristrettoGeneratorMultiples {
+		if got := hex.EncodeToString(ristrettoEncode(P)); got != want {
+			t.Errorf("%d·B = %s, want %s", i, got, want)
+		}
+		b, _ := hex.DecodeString(want)
+		Q, err := ristrettoDecode(b)
+		if err != nil {
+			t.Errorf("failed to decode %d·B: %v", i, err)
+		} else if !bytes.Equal(ristrettoEncode(Q), b) {
+			t.Errorf("%d·B does not round trip", i)
+		}
+		x, _ := curve.ScalarBaseMult([]byte{byte(i)})
+		if got := hex.EncodeToString(ed25519PointFromBigInts(x, big.NewInt(0))); got != want {
+			t.Errorf("ScalarBaseMult(%d) = %s, want %s", i, got, want)
+		}
+		P.Add(P, edwards25519.NewGeneratorPoint())
+	}
+}
+
+func TestRistrettoInvalidEncodings(t *testing.T) {
+	invalid := []string{
+		// non-canonical field encodings
+		"00ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"f3ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		// negative field element
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		// an Edwards25519 encoding of the generator is not a valid element
+		"5866666666666666666666666666666666666666666666666666666666666666",
+	}
+	curve := &Ristretto255Curve{}
+	for _, s := range invalid {
+		b, _ := hex.DecodeString(s)
+		if _, err := ristrettoDecode(b); err == nil {
+			t.Errorf("%s should not decode", s)
+		}
+		if curve.IsOnCurve(new(big.Int).SetBytes(b), big.NewInt(0)) {
+			t.Errorf("%s should not be on curve", s)
+		}
+	}
+	if curve.IsOnCurve(big.NewInt(0), big.NewInt(1)) {
+		t.Error("y must be zero")
+	}
+}
+
+func TestRistrettoFromUniformBytes(t *testing.T) {
+	// RFC 9496, appendix A.3
+	h := sha512.Sum512([]byte("Ristretto is traditionally a short shot of espresso coffee"))
+	want := "3066f82a1a747d45120d1740f14358531a8f04bbffe6a819f86dfe50f44a0a46"
+	if got := hex.EncodeToString(ristrettoEncode(ristrettoFromUniformBytes(h[:]))); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestRistrettoPoints(t *testing.T) {
+	_, _, Ux, _, Vx, _, err := initCurve("ristretto255")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		seed string
+		x    *big.Int
+	}{{"croc2", Ux}, {"croc1", Vx}} {
+		h := sha512.Sum512([]byte(tt.seed))
+		want := ristrettoEncode(ristrettoFromUniformBytes(h[:]))
+		if !bytes.Equal(ed25519PointFromBigInts(tt.x, big.NewInt(0)), want) {
+			t.Errorf("point for %s does not match the one-way map", tt.seed)
+		}
+	}
+	if Ux.Cmp(Vx) == 0 {
+		t.Error("U and V must differ")
+	}
+}
@@ -0,0 +1,146 @@
+package pake
+
+import (
+	"fmt"
+	"hash"
+	"math/big"
+)
+
+// Verifier is the record a server stores for a client in SPAKE2+ mode
+// instead of the password: the scalar w0 and the point L = w1·G. It
+// does not allow impersonating the client, and recovering the password
+// from it requires a dictionary attack.
+type Verifier struct {
+	Curve  string
+	W0     []byte
+	Lᵤ, Lᵥ *big.Int
+}
+
+// spake2PlusContext is the Context of the SPAKE2+ transcript.
+var spake2PlusContext = []byte("pake-v3 SPAKE2+")
+
+// NewVerifier computes the Verifier for the password pw on the given
+// curve, with the w0 and w1 derivation of this package, so it cannot
+// be used with other SPAKE2+ implementations. The identities in opts
+// are those of the client, i.e. LocalIdentity is the client (prover)
+// and RemoteIdentity the server (verifier); they must match the
+// identities used during the exchange.
+func NewVerifier(pw []byte, curve string, opts Options) (v *Verifier, err error) {
+	opts.Mode = ModeSPAKE2Plus
+	p, err := newPake(0, curve, opts)
+	if err != nil {
+		return
+	}
+	p.Pw = pw
+	if err = p.initSPAKE2Plus(curve, stretchPassword(pw, opts)); err != nil {
+		return
+	}
+	v = &Verifier{Curve: curve, W0: p.w}
+	v.Lᵤ, v.Lᵥ = scalarBaseMult(p.curve, p.w1)
+	return
+}
+
+// InitVerifier initializes the server (role 1) of a SPAKE2+ exchange
+// from the Verifier stored for the client, so that the server never
+// needs the password. The identities in opts are those of the server,
+// i.e. LocalIdentity is the server and RemoteIdentity the client.
+func InitVerifier(v *Verifier, opts Options) (p *Pake, err error) {
+	if v == nil {
+		return nil, fmt.Errorf("%w: nil verifier", ErrNotInitialized)
+	}
+	opts.Mode = ModeSPAKE2Plus
+	p, err = newPake(1, v.Curve, opts)
+	if err != nil {
+		return
+	}
+	if err = p.useRFC9382Points(v.Curve); err != nil {
+		return
+	}
+	if len(v.W0) != len(p.order.Bytes()) || new(big.Int).SetBytes(v.W0).Cmp(p.order) >= 0 {
+		return nil, fmt.Errorf("%w: w0", ErrInvalidVerifier)
+	}
+	if v.Lᵤ == nil || v.Lᵥ == nil {
+		return nil, fmt.Errorf("%w: L", ErrInvalidVerifier)
+	}
+	if err = p.validatePoint(v.Lᵤ, v.Lᵥ, "L"); err != nil {
+		return
+	}
+	p.w = v.W0
+	p.lᵤ, p.lᵥ = v.Lᵤ, v.Lᵥ
+	return
+}
+
+// initSPAKE2Plus uses the M and N points of RFC 9382 and derives w0
+// and w1 from the (stretched) password. The server only keeps L = w1·G.
+func (p *Pake) initSPAKE2Plus(curve string, pw []byte) (err error) {
+	if err = p.useRFC9382Points(curve); err != nil {
+		return
+	}
+	p.w, p.w1, err = spake2PlusScalars(p.hash, p.order, pw, p.idA, p.idB)
+	if err != nil {
+		return
+	}
+	if p.Role == 1 {
+		p.lᵤ, p.lᵥ = scalarBaseMult(p.curve, p.w1)
+		p.w1 = nil
+	}
+	return
+}
+
+// spake2PlusScalars derives w0 and w1 from the password and the
+// identities of the prover and the verifier with hash_to_field. The
+// derivation is specific to this package.
+func spake2PlusScalars(h func() hash.Hash, order *big.Int, pw, idProver, idVerifier []byte) (w0, w1 []byte, err error) {
+	input := appendLengthPrefixed(nil, pw, idProver, idVerifier)
+	w0, err = hashToScalar(h, order, input, []byte("pake-v3-SPAKE2+-w0"))
+	if err != nil {
+		return
+	}
+	w1, err = hashToScalar(h, order, input, []byte("pake-v3-SPAKE2+-w1"))
+	return
+}
+
+// multiplyW1 returns h·w1·(x,y), which only the client can compute.
+func (p *Pake) multiplyW1(x, y *big.Int) (*big.Int, *big.Int) {
+	x, y = scalarMult(p.curve, x, y, p.w1)
+	if h := curveCofactor(p.curve); h > 1 {
+		x, y = scalarMult(p.curve, x, y, []byte{byte(h)})
+	}
+	return x, y
+}
+
+// deriveSPAKE2PlusKeys computes the transcript TT and derives the
+// shared key and the confirmation keys from K_main.
+func (p *Pake) deriveSPAKE2PlusKeys() error {
+	// TT = len(Context) || Context || len(idProver) || idProver
+	//      || len(idVerifier) || idVerifier || len(M) || M || len(N) || N
+	//      || len(shareP) || shareP || len(shareV) || shareV
+	//      || len(Z) || Z || len(V) || V || len(w0) || w0
+	p.transcript = appendLengthPrefixed(nil,
+		append(append([]byte{}, spake2PlusContext...), p.aad...),
+		p.idA, p.idB,
+		p.encodePoint(p.Uᵤ, p.Uᵥ),
+		p.encodePoint(p.Vᵤ, p.Vᵥ),
+		p.encodePoint(p.Xᵤ, p.Xᵥ),
+		p.encodePoint(p.Yᵤ, p.Yᵥ),
+		p.encodePoint(p.Zᵤ, p.Zᵥ),
+		p.encodePoint(p.augVᵤ, p.augVᵥ),
+		p.w,
+	)
+	H := p.hash()
+	H.Write(p.transcript)
+	kMain := H.Sum(nil)
+	// K_confirmP || K_confirmV = KDF(nil, K_main, "ConfirmationKeys")
+	kc, err := hkdf(p.hash, kMain, nil, []byte("ConfirmationKeys"), 2*len(kMain))
+	if err != nil {
+		return err
+	}
+	// K_shared = KDF(nil, K_main, "SharedKey")
+	k, err := hkdf(p.hash, kMain, nil, []byte("SharedKey"), len(kMain))
+	if err != nil {
+		return err
+	}
+	p.kcA, p.kcB = kc[:len(kMain)], kc[len(kMain):]
+	p.K = k
+	return nil
+}
@@ -0,0 +1,133 @@
+package pake
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestSPAKE2Plus(t *testing.T) {
+	clientOpts := Options{Mode: ModeSPAKE2Plus, LocalIdentity: "client", RemoteIdentity: "server"}
+	serverOpts := Options{LocalIdentity: "server", RemoteIdentity: "client"}
+	for _, curve := range rfc9382Curves {
+		v, err := NewVerifier([]byte("password"), curve, clientOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the verifier is stored and loaded again by the server
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stored Verifier
+		if err = json.Unmarshal(b, &stored); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(b, []byte("password")) {
+			t.Errorf("verifier for %s contains the password", curve)
+		}
+
+		client, err := InitCurveWithOptions([]byte("password"), 0, curve, clientOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, err := InitVerifier(&stored, serverOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		update(t, client, server)
+
+		kC, _ := client.SessionKey()
+		kS, _ := server.SessionKey()
+		if !bytes.Equal(kC, kS) {
+			t.Errorf("session keys not equal for %s", curve)
+		}
+		cC, _ := client.Confirmation()
+		cS, _ := server.Confirmation()
+		if err = server.VerifyConfirmation(cC); err != nil {
+			t.Errorf("server failed to verify client for %s: %v", curve, err)
+		}
+		if err = client.VerifyConfirmation(cS); err != nil {
+			t.Errorf("client failed to verify server for %s: %v", curve, err)
+		}
+	}
+}
+
+func TestSPAKE2PlusServerFromPassword(t *testing.T) {
+	opts := Options{Mode: ModeSPAKE2Plus}
+	client, _ := InitCurveWithOptions([]byte("password"), 0, "p256", opts)
+	server, err := InitCurveWithOptions([]byte("password"), 1, "p256", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update(t, client, server)
+	cC, _ := client.Confirmation()
+	if err = server.VerifyConfirmation(cC); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSPAKE2PlusMismatch(t *testing.T) {
+	v, err := NewVerifier([]byte("password"), "p256", Options{LocalIdentity: "client", RemoteIdentity: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		pw   []byte
+		opts Options
+	}{
+		{"password", []byte("passw0rd"), Options{LocalIdentity: "client", RemoteIdentity: "server"}},
+		{"identity", []byte("password"), Options{LocalIdentity: "mallory", RemoteIdentity: "server"}},
+	}
+	for _, tt := range tests {
+		tt.opts.Mode = ModeSPAKE2Plus
+		client, _ := InitCurveWithOptions(tt.pw, 0, "p256", tt.opts)
+		server, _ := InitVerifier(v, Options{LocalIdentity: "server", RemoteIdentity: "client"})
+		update(t, client, server)
+		cC, _ := client.Confirmation()
+		if err = server.VerifyConfirmation(cC); err != ErrConfirmationFailed {
+			t.Errorf("%s mismatch should fail confirmation, got %v", tt.name, err)
+		}
+		cS, _ := server.Confirmation()
+		if err = client.VerifyConfirmation(cS); err != ErrConfirmationFailed {
+			t.Errorf("%s mismatch should fail confirmation, got %v", tt.name, err)
+		}
+	}
+}
+
+// TestSPAKE2PlusW0Only checks that knowing w0 without w1, as from a
+// leaked verifier, does not allow to impersonate the client.
+func TestSPAKE2PlusW0Only(t *testing.T) {
+	v, _ := NewVerifier([]byte("password"), "p256", Options{})
+	attacker, _ := InitCurveWithOptions([]byte("password"), 0, "p256", Options{Mode: ModeSPAKE2Plus})
+	attacker.w1 = make([]byte, len(attacker.w1))
+	attacker.w1[len(attacker.w1)-1] = 1
+	server, _ := InitVerifier(v, Options{})
+	update(t, attacker, server)
+	cA, _ := attacker.Confirmation()
+	if err := server.VerifyConfirmation(cA); err != ErrConfirmationFailed {
+		t.Errorf("client without w1 should fail confirmation, got %v", err)
+	}
+}
+
+func TestInvalidVerifier(t *testing.T) {
+	v, _ := NewVerifier([]byte("password"), "p256", Options{})
+	tests := []struct {
+		name string
+		v    *Verifier
+	}{
+		{"nil", nil},
+		{"unknown curve", &Verifier{Curve: "bad", W0: v.W0, Lᵤ: v.Lᵤ, Lᵥ: v.Lᵥ}},
+		{"unsupported curve", &Verifier{Curve: "siec", W0: v.W0, Lᵤ: v.Lᵤ, Lᵥ: v.Lᵥ}},
+		{"short w0", &Verifier{Curve: "p256", W0: v.W0[1:], Lᵤ: v.Lᵤ, Lᵥ: v.Lᵥ}},
+		{"missing L", &Verifier{Curve: "p256", W0: v.W0}},
+		{"L not on curve", &Verifier{Curve: "p256", W0: v.W0, Lᵤ: v.Lᵤ, Lᵥ: new(big.Int).Add(v.Lᵥ, big.NewInt(1))}},
+	}
+	for _, tt := range tests {
+		if _, err := InitVerifier(tt.v, Options{}); err == nil {
+			t.Errorf("%s verifier should be rejected", tt.name)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package pake
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// stateVersion is the version of the serialized state.
+const stateVersion = 1
+
+// stateAD is the additional data authenticated with the state.
+var stateAD = []byte("pake-v3 state")
+
+// pakeState is the complete state of a Pake. The curve, the hash and
+// the order are restored from the curve name and the mode.
+type pakeState struct {
+	StateVersion int
+	Role         int
+	Curve        string
+	Mode         Mode
+	Version      int
+	Offered      int     `json:",omitempty"`
+	Offers       []Offer `json:",omitempty"`
+
+	Pw           []byte
+	IDA, IDB     []byte
+	W, W1        []byte
+	Lᵤ, Lᵥ       *big.Int
+	Aα           []byte
+	Aαᵤ, Aαᵥ     *big.Int
+	Xᵤ, Xᵥ       *big.Int
+	Yᵤ, Yᵥ       *big.Int
+	Vpwᵤ, Vpwᵥ   *big.Int
+	Upwᵤ, Upwᵥ   *big.Int
+	Zᵤ, Zᵥ       *big.Int
+	AugVᵤ, AugVᵥ *big.Int
+	Gᵤ, Gᵥ       *big.Int
+	SID          []byte
+	ADA, ADB     []byte
+	K            []byte
+	Transcript   []byte
+	KcA, KcB     []byte
+	AAD          []byte
+	Candidates   map[string]*pakeState `json:",omitempty"`
+}
+
+// MarshalState serializes the complete state of p, including the
+// password and the secret, encrypted and authenticated with AES-GCM
+// under encKey, which must be 16, 24 or 32 bytes. RestoreState resumes
+// the exchange from it, e.g. in another process.
+//
+// A state must be restored at most once: completing the exchange twice
+// from the same state reuses the secret.
+func (p *Pake) MarshalState(encKey []byte) ([]byte, error) {
+	if p == nil {
+		return nil, ErrNotInitialized
+	}
+	plaintext, err := json.Marshal(p.state())
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newStateCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, stateAD), nil
+}
+
+// RestoreState returns the Pake serialized by MarshalState under
+// encKey. The restored Pake reads its randomness from crypto/rand.
+func RestoreState(b, encKey []byte) (*Pake, error) {
+	aead, err := newStateCipher(encKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidState, err)
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, ErrInvalidState
+	}
+	nonce, ciphertext := b[:aead.NonceSize()], b[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, stateAD)
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+	var s *pakeState
+	if err = json.Unmarshal(plaintext, &s); err != nil || s == nil || s.StateVersion != stateVersion {
+		return nil, ErrInvalidState
+	}
+	return restoreState(s)
+}
+
+func newStateCipher(encKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// state returns the serializable state of p.
+func (p *Pake) state() *pakeState {
+	s := &pakeState{
+		StateVersion: stateVersion,
+		Role:         p.Role,
+		Curve:        p.Curve,
+		Mode:         p.Mode,
+		Version:      p.Version,
+		Offered:      p.offeredVersion,
+		Offers:       p.Offers,
+		Pw:           p.Pw,
+		IDA:          p.idA,
+		IDB:          p.idB,
+		W:            p.w,
+		W1:           p.w1,
+		Lᵤ:           p.lᵤ,
+		Lᵥ:           p.lᵥ,
+		Aα:           p.Aα,
+		Aαᵤ:          p.Aαᵤ,
+		Aαᵥ:          p.Aαᵥ,
+		Xᵤ:           p.Xᵤ,
+		Xᵥ:           p.Xᵥ,
+		Yᵤ:           p.Yᵤ,
+		Yᵥ:           p.Yᵥ,
+		Vpwᵤ:         p.Vpwᵤ,
+		Vpwᵥ:         p.Vpwᵥ,
+		Upwᵤ:         p.Upwᵤ,
+		Upwᵥ:         p.Upwᵥ,
+		Zᵤ:           p.Zᵤ,
+		Zᵥ:           p.Zᵥ,
+		AugVᵤ:        p.augVᵤ,
+		AugVᵥ:        p.augVᵥ,
+		Gᵤ:           p.gᵤ,
+		Gᵥ:           p.gᵥ,
+		SID:          p.sid,
+		ADA:          p.adA,
+		ADB:          p.adB,
+		K:            p.K,
+		Transcript:   p.transcript,
+		KcA:          p.kcA,
+		KcB:          p.kcB,
+		AAD:          p.aad,
+	}
+	if p.negotiation != nil {
+		s.Candidates = make(map[string]*pakeState)
+		for curve, c := range p.negotiation.candidates {
+			s.Candidates[curve] = c.state()
+		}
+	}
+	return s
+}
+
+// restoreState rebuilds a Pake from its state.
+func restoreState(s *pakeState) (p *Pake, err error) {
+	if s.Candidates != nil {
+		p = &Pake{
+			Role:        s.Role,
+			Version:     s.Version,
+			Mode:        s.Mode,
+			Offers:      s.Offers,
+			negotiation: &negotiation{candidates: make(map[string]*Pake)},
+		}
+		for curve, cs := range s.Candidates {
+			if cs == nil || cs.Curve != curve {
+				return nil, ErrInvalidState
+			}
+			if p.negotiation.candidates[curve], err = restoreState(cs); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	}
+
+	p, err = newPake(s.Role, s.Curve, Options{Mode: s.Mode, TranscriptVersion: s.Version})
+	if err != nil {
+		return nil, err
+	}
+	switch p.Mode {
+	case ModeLegacy:
+	case ModeRFC9382, ModeSPAKE2Plus:
+		err = p.useRFC9382Points(s.Curve)
+	case ModeCPace:
+		err = p.useCPaceSuite(s.Curve)
+	default:
+		err = ErrUnknownMode
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.offeredVersion = s.Offered
+	p.Offers = s.Offers
+	p.Pw = s.Pw
+	p.idA, p.idB = s.IDA, s.IDB
+	p.w, p.w1 = s.W, s.W1
+	p.lᵤ, p.lᵥ = s.Lᵤ, s.Lᵥ
+	p.Aα = s.Aα
+	p.Aαᵤ, p.Aαᵥ = s.Aαᵤ, s.Aαᵥ
+	p.Xᵤ, p.Xᵥ = s.Xᵤ, s.Xᵥ
+	p.Yᵤ, p.Yᵥ = s.Yᵤ, s.Yᵥ
+	p.Vpwᵤ, p.Vpwᵥ = s.Vpwᵤ, s.Vpwᵥ
+	p.Upwᵤ, p.Upwᵥ = s.Upwᵤ, s.Upwᵥ
+	p.Zᵤ, p.Zᵥ = s.Zᵤ, s.Zᵥ
+	p.augVᵤ, p.augVᵥ = s.AugVᵤ, s.AugVᵥ
+	p.gᵤ, p.gᵥ = s.Gᵤ, s.Gᵥ
+	p.sid = s.SID
+	p.adA, p.adB = s.ADA, s.ADB
+	p.K = s.K
+	p.transcript = s.Transcript
+	p.kcA, p.kcB = s.KcA, s.KcB
+	p.aad = s.AAD
+	return p, nil
+}
@@ -0,0 +1,116 @@
+package pake
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var testStateKey = bytes.Repeat([]byte{7}, 32)
+
+// restore round-trips p through MarshalState and RestoreState.
+func restore(t *testing.T, p *Pake) *Pake {
+	t.Helper()
+	b, err := p.MarshalState(testStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = RestoreState(b, testStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestState(t *testing.T) {
+	for _, mode := range []Mode{ModeLegacy, ModeRFC9382, ModeSPAKE2Plus, ModeCPace} {
+		curves := AvailableCurves()
+		switch mode {
+		case ModeRFC9382, ModeSPAKE2Plus:
+			curves = rfc9382Curves
+		case ModeCPace:
+			curves = cpaceCurves
+		}
+		for _, curve := range curves {
+			opts := Options{Mode: mode, LocalIdentity: "a", RemoteIdentity: "b"}
+			A, err := InitCurveWithOptions([]byte("password"), 0, curve, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.LocalIdentity, opts.RemoteIdentity = "b", "a"
+			B, err := InitCurveWithOptions([]byte("password"), 1, curve, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// both parties are restarted between every step
+			A = restore(t, A)
+			B = restore(t, B)
+			if err = B.Update(A.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			B = restore(t, B)
+			if err = A.Update(B.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			A = restore(t, A)
+			kA, _ := A.SessionKey()
+			kB, _ := B.SessionKey()
+			if !bytes.Equal(kA, kB) {
+				t.Errorf("mode %d: session keys not equal for %s", mode, curve)
+			}
+			cA, _ := A.Confirmation()
+			if err = B.VerifyConfirmation(cA); err != nil {
+				t.Errorf("mode %d: confirmation failed for %s: %v", mode, curve, err)
+			}
+		}
+	}
+}
+
+func TestStateNegotiation(t *testing.T) {
+	A, err := InitNegotiation([]byte("password"), 0, []string{"p384", "p256"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err := InitNegotiation([]byte("password"), 1, []string{"p256"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	A = restore(t, A)
+	B = restore(t, B)
+	update(t, A, B)
+	cB, _ := B.Confirmation()
+	if err = A.VerifyConfirmation(cB); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStateInvalid(t *testing.T) {
+	A, err := InitCurve([]byte("password"), 0, "p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := A.MarshalState(testStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RestoreState(b, bytes.Repeat([]byte{8}, 32)); err != ErrInvalidState {
+		t.Errorf("wrong key: expected ErrInvalidState, got %v", err)
+	}
+	b[len(b)-1] ^= 1
+	if _, err = RestoreState(b, testStateKey); err != ErrInvalidState {
+		t.Errorf("modified state: expected ErrInvalidState, got %v", err)
+	}
+	if _, err = RestoreState(b[:4], testStateKey); err != ErrInvalidState {
+		t.Errorf("truncated state: expected ErrInvalidState, got %v", err)
+	}
+	if _, err = A.MarshalState([]byte("short")); err == nil {
+		t.Error("invalid key size should fail")
+	}
+	if _, err = RestoreState(b, []byte("short")); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("invalid key size: expected ErrInvalidState, got %v", err)
+	}
+	var p *Pake
+	if _, err = p.MarshalState(testStateKey); err == nil {
+		t.Error("MarshalState should fail on a nil pake")
+	}
+}
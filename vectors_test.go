@@ -0,0 +1,258 @@
+package pake
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateVectors = flag.Bool("update", false, "regenerate the test vectors in testdata")
+
+// testVector is a known-answer test of a complete exchange. Byte
+// strings are hex encoded and points use the encoding of the
+// transcripts (SEC 1 uncompressed, or 32 bytes for ed25519 and
+// ristretto255).
+type testVector struct {
+	Mode          string `json:"mode"`
+	Version       int    `json:"transcriptVersion,omitempty"`
+	Password      string `json:"password"`
+	IdentityA     string `json:"identityA,omitempty"`
+	IdentityB     string `json:"identityB,omitempty"`
+	SessionID     string `json:"sessionID,omitempty"`
+	ScalarA       string `json:"scalarA"`
+	ScalarB       string `json:"scalarB"`
+	X             string `json:"X"`
+	Y             string `json:"Y"`
+	Z             string `json:"Z"`
+	MessageA      string `json:"messageA"`
+	MessageB      string `json:"messageB"`
+	K             string `json:"K"`
+	ConfirmationA string `json:"confirmationA"`
+	ConfirmationB string `json:"confirmationB"`
+}
+
+var vectorModes = map[string]Mode{
+	"legacy":     ModeLegacy,
+	"rfc9382":    ModeRFC9382,
+	"spake2plus": ModeSPAKE2Plus,
+	"cpace":      ModeCPace,
+}
+
+// vectorReader returns a reader that makes generateSecret pick the
+// given secret: legacy mode uses the 32 bytes read as they are, the
+// other modes reduce 16 more bytes than the scalar modulo the order.
+func vectorReader(mode Mode, scalar []byte) io.Reader {
+	if mode == ModeLegacy {
+		return bytes.NewReader(scalar)
+	}
+	return bytes.NewReader(append(make([]byte, 16), scalar...))
+}
+
+func vectorPath(curve string) string {
+	return filepath.Join("testdata", "vectors", curve+".json")
+}
+
+// runVector runs the exchange of v and returns both parties, failing
+// the test on any error.
+func runVector(t *testing.T, curve string, v testVector, randA, randB io.Reader) (A, B *Pake, msgA, msgB []byte) {
+	t.Helper()
+	pw, err := hex.DecodeString(v.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, err := hex.DecodeString(v.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode := vectorModes[v.Mode]
+	A, err = InitCurveWithOptions(pw, 0, curve, Options{
+		Mode:              mode,
+		LocalIdentity:     v.IdentityA,
+		RemoteIdentity:    v.IdentityB,
+		TranscriptVersion: v.Version,
+		Rand:              randA,
+		SessionID:         sid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	B, err = InitCurveWithOptions(pw, 1, curve, Options{
+		Mode:              mode,
+		LocalIdentity:     v.IdentityB,
+		RemoteIdentity:    v.IdentityA,
+		TranscriptVersion: v.Version,
+		Rand:              randB,
+		SessionID:         sid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgA, err = A.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if err = B.Update(msgA); err != nil {
+		t.Fatal(err)
+	}
+	if msgB, err = B.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if err = A.Update(msgB); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// generateVectors computes the vectors of a curve for every mode it
+// supports, with secrets drawn from deterministic readers.
+func generateVectors(t *testing.T, curve string) (vectors []testVector) {
+	// the first four are the vectors of the original transcript, which
+	// must never change
+	templates := []testVector{
+		{Mode: "legacy", Version: transcriptVersion1, Password: hex.EncodeToString([]byte("password"))},
+		{Mode: "legacy", Version: transcriptVersion1, Password: hex.EncodeToString([]byte{1, 2, 3}), IdentityA: "alice", IdentityB: "bob"},
+		{Mode: "rfc9382", Version: transcriptVersion1, Password: hex.EncodeToString([]byte("password")), IdentityA: "client", IdentityB: "server"},
+		{Mode: "spake2plus", Version: transcriptVersion1, Password: hex.EncodeToString([]byte("password")), IdentityA: "client", IdentityB: "server"},
+		{Mode: "legacy", Version: transcriptVersion2, Password: hex.EncodeToString([]byte("password"))},
+		{Mode: "cpace", Password: hex.EncodeToString([]byte("password")), IdentityA: "client", IdentityB: "server",
+			SessionID: hex.EncodeToString([]byte("session"))},
+		{Mode: "legacy", Version: transcriptVersion2, Password: hex.EncodeToString([]byte{1, 2, 3}), IdentityA: "alice", IdentityB: "bob"},
+	}
+	rfc9382 := false
+	for _, c := range rfc9382Curves {
+		rfc9382 = rfc9382 || c == curve
+	}
+	_, cpace := cpaceSuites[curve]
+	for i, v := range templates {
+		if v.Mode == "cpace" && !cpace || v.Mode != "legacy" && v.Mode != "cpace" && !rfc9382 {
+			continue
+		}
+		seed := curve + " " + v.Mode + " " + string(rune('0'+i))
+		A, B, msgA, msgB := runVector(t, curve, v, newTestReader(seed+" A"), newTestReader(seed+" B"))
+		cA, _ := A.Confirmation()
+		cB, _ := B.Confirmation()
+		v.ScalarA = hex.EncodeToString(A.Aα)
+		v.ScalarB = hex.EncodeToString(B.Aα)
+		v.X = hex.EncodeToString(A.encodePoint(A.Xᵤ, A.Xᵥ))
+		v.Y = hex.EncodeToString(A.encodePoint(A.Yᵤ, A.Yᵥ))
+		v.Z = hex.EncodeToString(A.encodePoint(A.Zᵤ, A.Zᵥ))
+		v.MessageA = hex.EncodeToString(msgA)
+		v.MessageB = hex.EncodeToString(msgB)
+		v.K = hex.EncodeToString(A.K)
+		v.ConfirmationA = hex.EncodeToString(cA)
+		v.ConfirmationB = hex.EncodeToString(cB)
+		vectors = append(vectors, v)
+	}
+	return
+}
+
+func TestVectors(t *testing.T) {
+	for _, curve := range AvailableCurves() {
+		if *updateVectors {
+			b, err := json.MarshalIndent(generateVectors(t, curve), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = os.WriteFile(vectorPath(curve), append(b, '\n'), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		b, err := os.ReadFile(vectorPath(curve))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vectors []testVector
+		if err = json.Unmarshal(b, &vectors); err != nil {
+			t.Fatal(err)
+		}
+		if len(vectors) == 0 {
+			t.Errorf("no vectors for %s", curve)
+		}
+		for i, v := range vectors {
+			mode, ok := vectorModes[v.Mode]
+			if !ok {
+				t.Fatalf("%s vector %d: unknown mode %q", curve, i, v.Mode)
+			}
+			a, _ := hex.DecodeString(v.ScalarA)
+			b, _ := hex.DecodeString(v.ScalarB)
+			A, B, msgA, msgB := runVector(t, curve, v, vectorReader(mode, a), vectorReader(mode, b))
+			cA, _ := A.Confirmation()
+			cB, _ := B.Confirmation()
+			for _, c := range []struct {
+				name      string
+				got, want string
+			}{
+				{"X", hex.EncodeToString(B.encodePoint(B.Xᵤ, B.Xᵥ)), v.X},
+				{"Y", hex.EncodeToString(A.encodePoint(A.Yᵤ, A.Yᵥ)), v.Y},
+				{"Z of A", hex.EncodeToString(A.encodePoint(A.Zᵤ, A.Zᵥ)), v.Z},
+				{"Z of B", hex.EncodeToString(B.encodePoint(B.Zᵤ, B.Zᵥ)), v.Z},
+				{"message A", hex.EncodeToString(msgA), v.MessageA},
+				{"message B", hex.EncodeToString(msgB), v.MessageB},
+				{"K of A", hex.EncodeToString(A.K), v.K},
+				{"K of B", hex.EncodeToString(B.K), v.K},
+				{"confirmation A", hex.EncodeToString(cA), v.ConfirmationA},
+				{"confirmation B", hex.EncodeToString(cB), v.ConfirmationB},
+			} {
+				if c.got != c.want {
+					t.Errorf("%s vector %d (%s): %s is %s, want %s", curve, i, v.Mode, c.name, c.got, c.want)
+				}
+			}
+		}
+	}
+}
+
+// TestVectorsOldPeer replays the role 1 message of the first vector,
+// which follows the original transcript, the way a peer that predates
+// the transcript versions sends it: the JSON of its public variables,
+// without Version. A role 0 with the default options must derive the
+// key of the vector.
+func TestVectorsOldPeer(t *testing.T) {
+	for _, curve := range AvailableCurves() {
+		b, err := os.ReadFile(vectorPath(curve))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vectors []testVector
+		if err = json.Unmarshal(b, &vectors); err != nil {
+			t.Fatal(err)
+		}
+		v := vectors[0]
+		if v.Mode != "legacy" || v.Version > transcriptVersion1 {
+			t.Fatalf("%s: the first vector is not of the original transcript", curve)
+		}
+		pw, _ := hex.DecodeString(v.Password)
+		a, _ := hex.DecodeString(v.ScalarA)
+		msgB, _ := hex.DecodeString(v.MessageB)
+
+		A, err := InitCurveWithOptions(pw, 0, curve, Options{Rand: vectorReader(ModeLegacy, a)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var q Pake
+		if err = q.UnmarshalBinary(msgB); err != nil {
+			t.Fatal(err)
+		}
+		old, err := json.Marshal(struct {
+			Role   int
+			Uᵤ, Uᵥ *big.Int
+			Vᵤ, Vᵥ *big.Int
+			Xᵤ, Xᵥ *big.Int
+			Yᵤ, Yᵥ *big.Int
+		}{1, A.Uᵤ, A.Uᵥ, A.Vᵤ, A.Vᵥ, A.Xᵤ, A.Xᵥ, q.Yᵤ, q.Yᵥ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = A.Update(old); err != nil {
+			t.Fatalf("%s: %v", curve, err)
+		}
+		if got := hex.EncodeToString(A.K); got != v.K {
+			t.Errorf("%s: session key %s, want %s", curve, got, v.K)
+		}
+	}
+}